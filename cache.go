@@ -0,0 +1,67 @@
+package godotenv
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// readCache holds what ReadCached read, by absolute filename.
+var readCache = struct {
+	sync.Mutex
+	files map[string]cachedFile
+}{files: map[string]cachedFile{}}
+
+type cachedFile struct {
+	stamp  fileStamp
+	envMap *EnvMap
+}
+
+// ReadCached is like Read, but only parses a file again if it changed since
+// it was last read by ReadCached, as told by its modification time and size.
+// This suits programs that read the same files over and over.
+//
+// References are expanded as a file is parsed, so values that refer to the
+// environment keep the values it had then, until the file changes. The cache
+// is shared by all goroutines, and safe for them to use at once. Each call
+// returns a map of its own.
+func ReadCached(filenames ...string) (*EnvMap, error) {
+	return readVia(readFileCached, true, filenames)
+}
+
+// ClearCache forgets all that ReadCached read, so that it parses every file
+// again.
+func ClearCache() {
+	readCache.Lock()
+	defer readCache.Unlock()
+	readCache.files = map[string]cachedFile{}
+}
+
+// readFileCached is readFile through the cache. The maps it returns are shared,
+// and not to be changed.
+func readFileCached(filename string, expand bool) (*EnvMap, error) {
+	name, err := expandHome(filename)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	stamp := statFile(name)
+
+	readCache.Lock()
+	cached, ok := readCache.files[name]
+	readCache.Unlock()
+	if ok && cached.stamp == stamp && !stamp.missing {
+		return cached.envMap, nil
+	}
+
+	envMap, err := readFile(name, expand)
+	readCache.Lock()
+	defer readCache.Unlock()
+	if err != nil {
+		delete(readCache.files, name)
+		return nil, err
+	}
+	readCache.files[name] = cachedFile{stamp: stamp, envMap: envMap}
+	return envMap, nil
+}
@@ -0,0 +1,56 @@
+package godotenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadCached(t *testing.T) {
+	defer ClearCache()
+	filename := filepath.Join(t.TempDir(), ".env")
+	write := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filename, mtime, mtime)
+	}
+	start := time.Now().Add(-time.Hour)
+	write("A=1", start)
+
+	first, err := ReadCached(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first.Set("A", "changed by caller")
+
+	// same size and time: the cached parse is used, unspoilt by the caller
+	write("A=2", start)
+	envMap, err := ReadCached(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, _ := envMap.Get("A"); v != "1" {
+		t.Errorf("Failed read cached: A=%s", v)
+	}
+
+	write("A=3", start.Add(time.Second))
+	envMap, _ = ReadCached(filename)
+	if v, _ := envMap.Get("A"); v != "3" {
+		t.Errorf("Failed read cached after change: A=%s", v)
+	}
+
+	write("A=4", start.Add(time.Second))
+	ClearCache()
+	envMap, _ = ReadCached(filename)
+	if v, _ := envMap.Get("A"); v != "4" {
+		t.Errorf("Failed read cached after clearing: A=%s", v)
+	}
+
+	os.Remove(filename)
+	if _, err := ReadCached(filename); err == nil {
+		t.Errorf("Failed read cached of a removed file")
+	}
+}
@@ -0,0 +1,65 @@
+package godotenv
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSVOptions tweaks how ReadCSVWithOptions reads a table.
+type CSVOptions struct {
+	// Comma separates the fields of a row. It is a comma if zero; use '\t'
+	// for TSV.
+	Comma rune
+
+	// Header skips the first row, which names the columns.
+	Header bool
+}
+
+// ReadCSV reads a map from the rows of a CSV table, such as one exported from
+// a spreadsheet, taking keys from column keyCol and values from column valCol,
+// counting from 0. Fields may be quoted, to hold commas, quotes or line breaks,
+// and values are taken as they are, without expanding references. Keys are
+// set in the order of the rows, and a key set again takes the later value.
+func ReadCSV(r io.Reader, keyCol, valCol int) (*EnvMap, error) {
+	return ReadCSVWithOptions(r, keyCol, valCol, CSVOptions{})
+}
+
+// ReadCSVWithOptions is ReadCSV, with a header row or other separators as
+// opts say.
+func ReadCSVWithOptions(r io.Reader, keyCol, valCol int, opts CSVOptions) (*EnvMap, error) {
+	if keyCol < 0 || valCol < 0 {
+		return nil, fmt.Errorf("invalid columns %d and %d", keyCol, valCol)
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // rows may have columns we don't need
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	if opts.Comma == '\t' {
+		cr.LazyQuotes = true // TSV doesn't quote
+	}
+
+	envMap := NewEnvMap()
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return envMap, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && opts.Header {
+			continue
+		}
+		if keyCol >= len(record) || valCol >= len(record) {
+			return nil, fmt.Errorf("row %d: %d columns, too few for columns %d and %d", row, len(record), keyCol, valCol)
+		}
+		key := strings.TrimSpace(record[keyCol])
+		if key == "" {
+			continue
+		}
+		envMap.Set(key, record[valCol])
+	}
+}
@@ -0,0 +1,44 @@
+package godotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	input := strings.Join([]string{
+		`name,description,value`,
+		`HOSTS,"the hosts, comma separated","a,b,c"`,
+		`GREETING,quoted,"say ""hi"""`,
+		`,blank key,skipped`,
+		`PORT,,8080`,
+	}, "\n")
+
+	envMap, err := ReadCSVWithOptions(strings.NewReader(input), 0, 2, CSVOptions{Header: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Pair{
+		{Key: "HOSTS", Val: "a,b,c"},
+		{Key: "GREETING", Val: `say "hi"`},
+		{Key: "PORT", Val: "8080"},
+	}
+	if !reflect.DeepEqual(envMap.Pairs(), expected) {
+		t.Errorf("Expected %v, got %v", expected, envMap.Pairs())
+	}
+
+	envMap, err = ReadCSV(strings.NewReader(input), 0, 2)
+	if v, _ := envMap.Get("name"); err != nil || v != "value" {
+		t.Errorf("Expected the header row to be read without Header, got %q %v", v, err)
+	}
+
+	envMap, err = ReadCSVWithOptions(strings.NewReader("A\t1\nB\tx\"y"), 0, 1, CSVOptions{Comma: '\t'})
+	if v, _ := envMap.Get("B"); err != nil || v != `x"y` {
+		t.Errorf("Failed TSV: %q %v", v, err)
+	}
+
+	if _, err := ReadCSV(strings.NewReader("A,1\nB"), 0, 1); err == nil {
+		t.Errorf("Expected an error for a short row")
+	}
+}
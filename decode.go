@@ -0,0 +1,147 @@
+package godotenv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DecodeStruct sets the fields of the struct v points to from the map, by the
+// keys named in their env tags:
+//
+//	type Config struct {
+//		Host    string        `env:"HOST"`
+//		Timeout time.Duration `env:"TIMEOUT"`
+//		DB      *Database     `env:"DB_"`
+//	}
+//
+// A field that is a struct, or a pointer to one, is decoded in turn, with its
+// tag prefixed to those of its own fields: if Database has a Host field tagged
+// HOST, DB.Host is set from DB_HOST. A nil pointer is only allocated if any of
+// the keys for the struct is present. Embedded structs without a tag share the
+// prefix of the struct they are in.
+//
+// Fields can be strings, booleans, numbers, durations, or string slices, which
+// are split on commas as by GetSlice. Fields without a tag, or whose key the
+// map lacks, are left alone. Errors name the field by its path, as in DB.Port.
+func (m *EnvMap) DecodeStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("DecodeStruct needs a non-nil pointer to a struct")
+	}
+	_, err := m.decodeStruct(rv.Elem(), "", "")
+	return err
+}
+
+// decodeStruct sets the fields of sv from the keys under prefix, reporting
+// whether any were present. path is the path of sv, for errors.
+func (m *EnvMap) decodeStruct(sv reflect.Value, prefix, path string) (bool, error) {
+	found := false
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		tag, tagged := field.Tag.Lookup("env")
+		fv := sv.Field(i)
+		if (!tagged && !field.Anonymous) || tag == "-" {
+			continue
+		}
+		if field.PkgPath != "" && !(field.Anonymous && fv.Kind() == reflect.Struct) {
+			continue // unexported, but for the promoted fields of embedded structs
+		}
+		key := prefix + tag
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+
+		switch {
+		case fv.Kind() == reflect.Struct:
+			ok, err := m.decodeStruct(fv, key, fieldPath)
+			found = found || ok
+			if err != nil {
+				return found, err
+			}
+		case fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct:
+			target := fv
+			if fv.IsNil() {
+				target = reflect.New(fv.Type().Elem())
+			}
+			ok, err := m.decodeStruct(target.Elem(), key, fieldPath)
+			if ok {
+				fv.Set(target)
+				found = true
+			}
+			if err != nil {
+				return found, err
+			}
+		case tag != "":
+			val, at := m.Get(key)
+			if at < 0 {
+				continue
+			}
+			found = true
+			if err := m.decodeValue(fv, key, val); err != nil {
+				return found, fmt.Errorf("%s: %s=%q: %w", fieldPath, key, val, err)
+			}
+		}
+	}
+	return found, nil
+}
+
+// decodeValue sets fv from val, the value of key.
+func (m *EnvMap) decodeValue(fv reflect.Value, key, val string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		elems := m.GetSlice(key, ",")
+		slice := reflect.MakeSlice(fv.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			slice.Index(i).SetString(elem)
+		}
+		fv.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package godotenv
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDatabase struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT"`
+}
+
+type testLogging struct {
+	Level string `env:"LEVEL"`
+}
+
+type testCommon struct {
+	Name string `env:"NAME"`
+}
+
+type testConfig struct {
+	testCommon
+	Debug    bool          `env:"DEBUG"`
+	Timeout  time.Duration `env:"TIMEOUT"`
+	Ratio    float64       `env:"RATIO"`
+	Hosts    []string      `env:"HOSTS"`
+	DB       testDatabase  `env:"DB_"`
+	Replica  *testDatabase `env:"REPLICA_"`
+	Logging  *testLogging  `env:"LOG_"`
+	Ignored  string
+	Skipped  string `env:"-"`
+	internal string `env:"INTERNAL"`
+}
+
+func TestDecodeStruct(t *testing.T) {
+	envMap, _ := Unmarshal(strings.Join([]string{
+		"NAME=app",
+		"DEBUG=true",
+		"TIMEOUT=1m30s",
+		"RATIO=0.5",
+		"HOSTS=a, b",
+		"DB_HOST=db",
+		"DB_PORT=5432",
+		"REPLICA_HOST=replica",
+		"Ignored=x",
+		"Skipped=x",
+		"INTERNAL=x",
+	}, "\n"))
+
+	var config testConfig
+	if err := envMap.DecodeStruct(&config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := testConfig{
+		testCommon: testCommon{Name: "app"},
+		Debug:      true,
+		Timeout:    90 * time.Second,
+		Ratio:      0.5,
+		Hosts:      []string{"a", "b"},
+		DB:         testDatabase{Host: "db", Port: 5432},
+		Replica:    &testDatabase{Host: "replica"},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestDecodeStructErrors(t *testing.T) {
+	envMap, _ := Unmarshal("REPLICA_PORT=x")
+	var config testConfig
+	err := envMap.DecodeStruct(&config)
+	if err == nil || !strings.HasPrefix(err.Error(), `Replica.Port: REPLICA_PORT="x": `) {
+		t.Errorf("Expected an error naming the field, got %v", err)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Expected the error to wrap the cause, got %v", err)
+	}
+
+	if err := envMap.DecodeStruct(config); err == nil {
+		t.Errorf("Expected an error decoding into a non-pointer")
+	}
+
+	var unsupported struct {
+		C chan int `env:"REPLICA_PORT"`
+	}
+	if err := envMap.DecodeStruct(&unsupported); err == nil {
+		t.Errorf("Expected an error decoding into an unsupported type")
+	}
+}
@@ -0,0 +1,78 @@
+package godotenv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of Diagnostic.
+const (
+	KindMalformedLine     = "malformed-line"
+	KindDuplicateKey      = "duplicate-key"
+	KindUndefinedVariable = "undefined-variable"
+	KindInvalidKey        = "invalid-key"
+	KindCaseCollision     = "case-collision"
+	KindTrailingContent   = "trailing-content"
+	KindKeyWhitespace     = "key-whitespace"
+	KindUnknownEscape     = "unknown-escape"
+	KindUnresolved        = "unresolved"
+	KindUnquotedJSON      = "unquoted-json"
+	KindInclude           = "include"
+)
+
+// Diagnostic describes a problem found on a line of env content.
+type Diagnostic struct {
+	// File is the included file the line is in, as with the Includes
+	// option, or empty for a line of the content parsed itself.
+	File    string
+	Line    int
+	Kind    string
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	if d.File != "" {
+		return fmt.Sprintf("%s: line %d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("line %d: %s", d.Line, d.Message)
+}
+
+// DiagnosticsError is returned by ParseStrict when problems were found.
+type DiagnosticsError []Diagnostic
+
+func (e DiagnosticsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, d := range e {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ParseStrict is like ParseWithOptions, but treats as errors not only
+// malformed lines, but also duplicate keys, references to undefined variables,
+// keys that don't make valid variable names or have whitespace around them, as
+// in KEY =value, and anything but a comment after a quoted value.
+//
+// All problems are reported together in a DiagnosticsError; the map holds
+// whatever could be parsed regardless.
+func ParseStrict(r io.Reader, opts ParseOptions) (*EnvMap, error) {
+	diags, envMap, err := ParseDiagnostics(r, opts)
+	if err == nil && len(diags) > 0 {
+		err = DiagnosticsError(diags)
+	}
+	return envMap, err
+}
+
+// ParseDiagnostics parses as much as it can of r, returning the problems found
+// in order of appearance along with all keys and values it could read. Lines
+// it couldn't read are skipped. The error is only set if r fails.
+//
+// This is meant for linters and editors, which can still offer what was
+// parsed while pointing out what wasn't.
+func ParseDiagnostics(r io.Reader, opts ParseOptions) ([]Diagnostic, *EnvMap, error) {
+	p := newParser(opts)
+	p.collect = true
+	err := p.parse(r)
+	return p.diags, p.envMap, err
+}
@@ -0,0 +1,198 @@
+package godotenv
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	os.Unsetenv("UNDEFINED_FOR_DIAGNOSTICS")
+	input := strings.Join([]string{
+		"# comment",
+		"GOOD=1",
+		"INVALID LINE",
+		"GOOD=2",
+		"REF=${UNDEFINED_FOR_DIAGNOSTICS}",
+		"BAD-KEY=3",
+		"LAST=${GOOD}",
+	}, "\n")
+
+	diags, envMap, err := ParseDiagnostics(strings.NewReader(input), ParseOptions{Expand: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		line int
+		kind string
+	}{
+		{3, KindMalformedLine},
+		{4, KindDuplicateKey},
+		{5, KindUndefinedVariable},
+		{6, KindInvalidKey},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(expected), diags)
+	}
+	for i, e := range expected {
+		if diags[i].Line != e.line || diags[i].Kind != e.kind {
+			t.Errorf("Expected diagnostic %d to be %s on line %d, got %v", i, e.kind, e.line, diags[i])
+		}
+	}
+
+	// everything else is still parsed
+	keys := []string{}
+	envMap.Iter(func(k, v string) { keys = append(keys, k) })
+	if !reflect.DeepEqual(keys, []string{"GOOD", "REF", "BAD-KEY", "LAST"}) {
+		t.Errorf("Unexpected keys parsed: %v", keys)
+	}
+	if v, _ := envMap.Get("LAST"); v != "2" {
+		t.Errorf("Expected LAST to be '2', got '%s'", v)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	_, err := ParseStrict(strings.NewReader("A=1\nB=2"), ParseOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	envMap, err := ParseStrict(strings.NewReader("A=1\nA=2"), ParseOptions{})
+	diags, ok := err.(DiagnosticsError)
+	if !ok || len(diags) != 1 || diags[0].Kind != KindDuplicateKey {
+		t.Errorf("Expected a duplicate key error, got %v", err)
+	}
+	if err != nil && err.Error() != "line 2: duplicate key A" {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+	if v, _ := envMap.Get("A"); v != "2" {
+		t.Errorf("Expected A to be '2', got '%s'", v)
+	}
+}
+
+func TestParseStrictTrailingContent(t *testing.T) {
+	for _, line := range []string{`KEY="v" # c`, `KEY="v"  `, `KEY='v'`} {
+		if _, err := ParseStrict(strings.NewReader(line), ParseOptions{}); err != nil {
+			t.Errorf("Unexpected error for %s: %v", line, err)
+		}
+	}
+	for _, line := range []string{`KEY="v";`, `KEY="v" trailing`, `KEY='v' "w"`} {
+		_, err := ParseStrict(strings.NewReader(line), ParseOptions{})
+		diags, ok := err.(DiagnosticsError)
+		if !ok || len(diags) != 1 || diags[0].Kind != KindTrailingContent {
+			t.Errorf("Expected a trailing content error for %s, got %v", line, err)
+		}
+	}
+}
+
+func TestParseStrictUnquotedJSON(t *testing.T) {
+	for _, line := range []string{`CONFIG='{"a":1}'`, `CONFIG="[1, 2]"`, `CONFIG=a{b}`} {
+		if _, err := ParseStrict(strings.NewReader(line), ParseOptions{}); err != nil {
+			t.Errorf("Unexpected error for %s: %v", line, err)
+		}
+	}
+	for _, line := range []string{`CONFIG={"a":1}`, `CONFIG= [1, 2]`} {
+		_, err := ParseStrict(strings.NewReader(line), ParseOptions{})
+		diags, ok := err.(DiagnosticsError)
+		if !ok || len(diags) != 1 || diags[0].Kind != KindUnquotedJSON {
+			t.Errorf("Expected an unquoted JSON error for %s, got %v", line, err)
+		}
+	}
+}
+
+func TestParseDiagnosticsKeyWhitespace(t *testing.T) {
+	input := strings.Join([]string{
+		"A=1",
+		"B =2",
+		"C\t=3",
+		"  export D=4",
+		"E: 5",
+		"F : 6",
+		"\tG=7",
+		"export H=8",
+	}, "\n")
+
+	diags, envMap, _ := ParseDiagnostics(strings.NewReader(input), ParseOptions{})
+	var lines []int
+	for _, d := range diags {
+		if d.Kind == KindKeyWhitespace {
+			lines = append(lines, d.Line)
+		}
+	}
+	if len(diags) != len(lines) || !reflect.DeepEqual(lines, []int{2, 3, 4, 6, 7}) {
+		t.Errorf("Expected key whitespace diagnostics on lines 2, 3, 4, 6 and 7, got %v", diags)
+	}
+	if v, _ := envMap.Get("G"); v != "7" {
+		t.Errorf("Expected G to be '7', got '%s'", v)
+	}
+	if v, _ := envMap.Get("C"); v != "3" {
+		t.Errorf("Expected C to be '3', got '%s'", v)
+	}
+}
+
+func TestParseReportsLine(t *testing.T) {
+	_, err := Parse(strings.NewReader("A=1\n\nlol$wut"), false)
+	d, ok := err.(Diagnostic)
+	if !ok || d.Line != 3 || d.Kind != KindMalformedLine {
+		t.Errorf("Expected a malformed line error on line 3, got %v", err)
+	}
+}
+
+func TestParseDiagnosticsCaseCollisions(t *testing.T) {
+	input := "Path=a\nOTHER=b\nPATH=c\nPath=d\npath=e"
+
+	diags, _, _ := ParseDiagnostics(strings.NewReader(input), ParseOptions{})
+	for _, d := range diags {
+		if d.Kind == KindCaseCollision {
+			t.Errorf("Unexpected case collision without DetectCaseCollisions: %v", d)
+		}
+	}
+
+	diags, envMap, _ := ParseDiagnostics(strings.NewReader(input), ParseOptions{DetectCaseCollisions: true})
+	expected := []Diagnostic{
+		{Line: 3, Kind: KindCaseCollision, Message: "key PATH differs only by case from Path on line 1"},
+		{Line: 4, Kind: KindDuplicateKey, Message: "duplicate key Path"},
+		{Line: 5, Kind: KindCaseCollision, Message: "key path differs only by case from Path on line 1"},
+	}
+	if !reflect.DeepEqual(diags, expected) {
+		t.Errorf("Expected diagnostics %v, got %v", expected, diags)
+	}
+	if envMap.Len() != 4 {
+		t.Errorf("Expected colliding keys to be kept apart, got %d keys", envMap.Len())
+	}
+
+	if _, err := ParseStrict(strings.NewReader("Path=a\nPATH=b"), ParseOptions{DetectCaseCollisions: true}); err == nil {
+		t.Error("Expected ParseStrict to fail on a case collision")
+	}
+}
+
+func TestParseKeyCase(t *testing.T) {
+	input := "Path=a\nhome=b\nREF=${home}\nPATH=c\n"
+
+	tests := []struct {
+		keyCase  KeyCase
+		expected []Pair
+	}{
+		{KeyAsIs, []Pair{{Key: "Path", Val: "a"}, {Key: "home", Val: "b"}, {Key: "REF", Val: "b", Raw: "${home}"}, {Key: "PATH", Val: "c"}}},
+		{KeyUpper, []Pair{{Key: "PATH", Val: "c"}, {Key: "HOME", Val: "b"}, {Key: "REF", Val: "b", Raw: "${home}"}}},
+		{KeyLower, []Pair{{Key: "path", Val: "c"}, {Key: "home", Val: "b"}, {Key: "ref", Val: "b", Raw: "${home}"}}},
+	}
+	for _, tt := range tests {
+		diags, envMap, err := ParseDiagnostics(strings.NewReader(input), ParseOptions{Expand: true, LowercaseNames: true, KeyCase: tt.keyCase})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(envMap.entries, tt.expected) {
+			t.Errorf("Expected key case %d to give %v, got %v", tt.keyCase, tt.expected, envMap.entries)
+		}
+		if tt.keyCase == KeyAsIs {
+			if len(diags) != 0 {
+				t.Errorf("Expected no diagnostics, got %v", diags)
+			}
+		} else if len(diags) != 1 || diags[0].Kind != KindCaseCollision || diags[0].Line != 4 {
+			t.Errorf("Expected a case collision on line 4 with key case %d, got %v", tt.keyCase, diags)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package godotenv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// document keeps the lines of an env file verbatim, so that single assignments
+// can be rewritten without disturbing comments, blank lines or ordering. The
+// byte order mark and line breaks of the file are kept too.
+type document struct {
+	lines []docLine
+	bom   []byte
+	crlf  bool
+}
+
+// docLine is a line of the document, or all the lines of a quoted value
+// spanning several, joined by \n.
+type docLine struct {
+	text string
+	key  string // empty for comments, blank lines and lines we can't parse
+}
+
+func parseDocument(r io.Reader) (*document, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	text, err := ioutil.ReadAll(decoded)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(bytes.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{bom: byteOrderMark(data)}
+	if eol := bytes.IndexByte(text, '\n'); eol > 0 && text[eol-1] == '\r' {
+		doc.crlf = true
+	}
+	for i := 0; i < len(lines); i++ {
+		first := lines[i]
+		line := docLine{text: first}
+		if !isIgnoredLine(first) {
+			if end := multilineEnd(lines, i); end > i {
+				line.text = strings.Join(lines[i:end+1], "\n")
+				i = end
+			}
+			if key, _, err := parseLine(first, NewEnvMap(), false); err == nil {
+				line.key = key
+			}
+		}
+		doc.lines = append(doc.lines, line)
+	}
+	return doc, nil
+}
+
+// multilineEnd returns the index of the line closing a quoted value opened on
+// lines[start], or start if the value is a single line after all. Without a
+// closing quote, or when every line up to it reads as a line of its own, the
+// lines are left apart, as Parse without the Multiline option would read them.
+func multilineEnd(lines []string, start int) int {
+	quote := unclosedQuote(lines[start], "=:")
+	if quote == 0 {
+		return start
+	}
+	separate := true
+	for end := start + 1; end < len(lines); end++ {
+		if !isIgnoredLine(lines[end]) {
+			if _, _, err := parseLine(lines[end], NewEnvMap(), false); err != nil {
+				separate = false
+			}
+		}
+		if closingQuote(lines[end], quote) >= 0 {
+			if separate {
+				return start
+			}
+			return end
+		}
+	}
+	return start
+}
+
+// Format tidies up the layout of env content without changing what it says:
+// trailing whitespace is trimmed off lines, runs of blank lines are collapsed
+// into one, blank lines at the start and end are dropped, and the last line
+// ends in a newline. Lines within quoted values spanning several lines are
+// left as they are, and so are the byte order mark and line breaks.
+func Format(src []byte) ([]byte, error) {
+	doc, err := parseDocument(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []docLine
+	for _, line := range doc.lines {
+		line.text = strings.TrimRight(line.text, " \t")
+		if line.text == "" && (len(lines) == 0 || lines[len(lines)-1].text == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 && lines[len(lines)-1].text == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	doc.lines = lines
+	return doc.bytes(), nil
+}
+
+// set rewrites every assignment of key in place, or appends a new one if the
+// key is not assigned anywhere in the document.
+func (d *document) set(key, val string) {
+	found := false
+	for i, line := range d.lines {
+		if line.key != key {
+			continue
+		}
+		text := formatLine(key, val)
+		if exportRegex.MatchString(line.text) {
+			text = "export " + text
+		}
+		d.lines[i].text = text
+		found = true
+	}
+	if !found {
+		d.lines = append(d.lines, docLine{text: formatLine(key, val), key: key})
+	}
+}
+
+func (d *document) bytes() []byte {
+	eol := "\n"
+	if d.crlf {
+		eol = "\r\n"
+	}
+	var b strings.Builder
+	for _, line := range d.lines {
+		b.WriteString(strings.Replace(line.text, "\n", eol, -1))
+		b.WriteString(eol)
+	}
+	return encodeLike(d.bom, b.String())
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and renames
+// it into place, so readers never see a partially written file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,85 @@
+package godotenv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeReader returns the content of r as UTF-8. Content starting with a
+// UTF-16 byte order mark is decoded accordingly, and a UTF-8 byte order mark
+// is dropped. Anything else is passed through as is.
+func decodeReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(bomUTF8)) // short reads are fine, errors recur below
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		br.Discard(len(bomUTF8))
+		return br, nil
+	case bytes.HasPrefix(head, bomUTF16LE):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(head, bomUTF16BE):
+		order = binary.BigEndian
+	default:
+		return br, nil
+	}
+
+	br.Discard(len(bomUTF16LE))
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, errors.New("truncated UTF-16 content")
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return strings.NewReader(string(utf16.Decode(units))), nil
+}
+
+// byteOrderMark returns the byte order mark data starts with, if any.
+func byteOrderMark(data []byte) []byte {
+	for _, bom := range [][]byte{bomUTF8, bomUTF16LE, bomUTF16BE} {
+		if bytes.HasPrefix(data, bom) {
+			return bom
+		}
+	}
+	return nil
+}
+
+// encodeLike encodes text as content starting with bom is encoded, the mark
+// included: UTF-16 in the byte order it gives, or else UTF-8.
+func encodeLike(bom []byte, text string) []byte {
+	var order binary.ByteOrder
+	switch {
+	case bytes.Equal(bom, bomUTF16LE):
+		order = binary.LittleEndian
+	case bytes.Equal(bom, bomUTF16BE):
+		order = binary.BigEndian
+	default:
+		return append(append([]byte{}, bom...), text...)
+	}
+
+	units := utf16.Encode([]rune(text))
+	data := make([]byte, len(bom)+2*len(units))
+	copy(data, bom)
+	for i, unit := range units {
+		order.PutUint16(data[len(bom)+2*i:], unit)
+	}
+	return data
+}
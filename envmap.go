@@ -2,47 +2,188 @@ package godotenv
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
 type Pair struct {
 	Key, Val string
+
+	// Raw is the value as written, before references in it were expanded,
+	// if that changed it. It is empty otherwise.
+	Raw string
+
+	// Quote is how the value was quoted, if parsed with PreserveQuotes.
+	Quote Quote
+
+	// Required marks the key as required, if parsed with RequiredDirectives.
+	Required bool
+}
+
+// Quote is how a value is quoted in env content.
+type Quote int
+
+const (
+	QuoteUnknown Quote = iota // not recorded; Marshal uses double quotes
+	QuoteNone
+	QuoteSingle
+	QuoteDouble
+)
+
+// RawVal returns the value as written: Raw, or Val if expansion left it as it
+// was.
+func (p Pair) RawVal() string {
+	if p.Raw != "" {
+		return p.Raw
+	}
+	return p.Val
 }
 
 type EnvMap struct {
 	entries []Pair
 	keys    map[string]int
+	less    func(a, b Pair) bool // see SetOrder
 }
 
 func NewEnvMap() *EnvMap {
 	return &EnvMap{keys: make(map[string]int)}
 }
 
+// NewEnvMapCap returns an empty map with room for n entries, for when the
+// expected size is known up front.
+func NewEnvMapCap(n int) *EnvMap {
+	return &EnvMap{entries: make([]Pair, 0, n), keys: make(map[string]int, n)}
+}
+
+// FromMap returns a map of the entries of m, sorted by key.
+func FromMap(m map[string]string) *EnvMap {
+	return FromMapOrdered(m, nil)
+}
+
+// FromMapOrdered returns a map of the entries of m, with the keys listed in
+// order first, in that order, followed by the rest sorted by key. Keys listed
+// in order but missing from m are skipped.
+func FromMapOrdered(m map[string]string, order []string) *EnvMap {
+	r := NewEnvMapCap(len(m))
+	for _, k := range order {
+		if v, ok := m[k]; ok {
+			r.Set(k, v)
+		}
+	}
+
+	var rest []string
+	for k := range m {
+		if _, at := r.Get(k); at < 0 {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		r.Set(k, m[k])
+	}
+	return r
+}
+
 func (m *EnvMap) Len() int {
 	return len(m.entries)
 }
 
+// Size returns the number of bytes the entries take up in the environment of
+// a process, as KEY=value strings ended by a NUL byte, which is what counts
+// towards limits like ARG_MAX. There is no quoting or escaping in that form,
+// so it is also the length of what EmitNUL writes.
+func (m *EnvMap) Size() int {
+	n := 0
+	for _, p := range m.entries {
+		n += len(p.Key) + len(p.Val) + 2
+	}
+	return n
+}
+
+// MaxKeyLen returns the length in bytes of the longest key, or 0 for an empty
+// map, for lining up values in columns:
+//
+//	w := m.MaxKeyLen()
+//	m.Export(os.Stdout, func(i int, k, v string) string {
+//		return fmt.Sprintf("%-*s = %s\n", w, k, v)
+//	})
+func (m *EnvMap) MaxKeyLen() int {
+	n := 0
+	for _, p := range m.entries {
+		if len(p.Key) > n {
+			n = len(p.Key)
+		}
+	}
+	return n
+}
+
 // Set stores a key-value pair in the map.
 // If the key existed previously, the entry remains in place, and the old
 // value and its index are returned.
 // If the key did not exist, an empty string and negative index are returned.
 func (m *EnvMap) Set(key, val string) (string, int) {
+	return m.setPair(Pair{Key: key, Val: val})
+}
+
+// With sets a key-value pair like Set, and returns the map, so that calls
+// can be chained:
+//
+//	m := NewEnvMap().With("HOST", "localhost").With("PORT", "8080")
+func (m *EnvMap) With(key, val string) *EnvMap {
+	m.Set(key, val)
+	return m
+}
+
+// setPair is Set for a whole entry, so that its raw value is kept.
+func (m *EnvMap) setPair(p Pair) (string, int) {
 	var r string
 	var ok bool
 	var at int
-	if at, ok = m.keys[key]; ok {
+	if at, ok = m.keys[p.Key]; ok {
 		r = m.entries[at].Val
-		m.entries[at] = Pair{Key: key, Val: val}
+		m.entries[at] = p
 	} else {
 		at = -1
-		m.entries = append(m.entries, Pair{Key: key, Val: val})
-		m.keys[key] = len(m.entries) - 1
+		m.entries = append(m.entries, p)
+		m.keys[p.Key] = len(m.entries) - 1
+	}
+	if m.less != nil {
+		m.reindex()
 	}
 	return r, at
 }
 
+// SetOrder keeps the entries sorted by less from now on, rather than in the
+// order they were set in, so that Iter, Emit, Marshal and everything else that
+// goes by the order of the map follows it. Entries that less doesn't tell
+// apart keep their order. A nil less goes back to the order of setting, from
+// the current one.
+//
+// Positional operations, such as SetAt, Move and Swap, have no lasting effect
+// with an order set, as the entries are sorted again right away. Maps derived
+// from this one, as by Pick, don't share its order.
+func (m *EnvMap) SetOrder(less func(a, b Pair) bool) {
+	m.less = less
+	m.reindex()
+}
+
+// SetIfAbsent stores a key-value pair in the map unless the key is already
+// present, reporting whether it did. This is how Load treats the environment.
+func (m *EnvMap) SetIfAbsent(key, val string) bool {
+	if _, ok := m.keys[key]; ok {
+		return false
+	}
+	m.Set(key, val)
+	return true
+}
+
 // Set stores a key-value pair in the map.
 // If the key existed previously, the place of the key is moved, and the old
 // value and index are returned.
@@ -52,11 +193,7 @@ func (m *EnvMap) SetAt(key, val string, at int) (string, int) {
 	var was string
 	if ok {
 		was = m.entries[ex].Val
-		rpl := m.entries[:ex]
-		if ex < len(m.entries)-1 {
-			rpl = append(rpl, m.entries[ex+1])
-		}
-		m.entries = rpl
+		m.entries = append(m.entries[:ex], m.entries[ex+1:]...)
 		if ex < at {
 			at -= 1
 		}
@@ -75,14 +212,17 @@ func (m *EnvMap) SetAt(key, val string, at int) (string, int) {
 	rpl = append(rpl, Pair{Key: key, Val: val})
 	rpl = append(rpl, m.entries[at:]...)
 	m.entries = rpl
-	m.keys = make(map[string]int)
-	for ix, pair := range m.entries {
-		m.keys[pair.Key] = ix
-	}
+	m.reindex()
 
 	return was, ex
 }
 
+// Prepend stores a key-value pair at the front of the map, moving the key
+// there if it existed previously. It is SetAt with a position of 0.
+func (m *EnvMap) Prepend(key, val string) (string, int) {
+	return m.SetAt(key, val, 0)
+}
+
 // Get returns a keyed value and its place in our collection, or
 // empty and a negative number if it did not exist.
 func (m *EnvMap) Get(key string) (string, int) {
@@ -93,6 +233,123 @@ func (m *EnvMap) Get(key string) (string, int) {
 	return "", -1
 }
 
+// GetExpanded returns the value of key with its references expanded against
+// the rest of the map at the time of the call, as Resolve would, and whether
+// the key is present. The map itself is left as it is. This suits maps read
+// without expansion, as by ReadNoExpand, which keep values as written for
+// Marshal while GetExpanded gives what they stand for.
+//
+// Single-quoted values are only kept literal if that was recorded, as by
+// PreserveQuotes; otherwise nothing tells them apart from values that were
+// never expanded, and their references are expanded too.
+func (m *EnvMap) GetExpanded(key string) (string, bool) {
+	if _, ok := m.keys[key]; !ok {
+		return "", false
+	}
+	r := NewEnvMapCap(len(m.entries))
+	for _, p := range m.entries {
+		r.setPair(Pair{Key: p.Key, Val: p.RawVal(), Quote: p.Quote})
+	}
+	r.Resolve() // keys in cycles keep their values as written
+	val, _ := r.Get(key)
+	return val, true
+}
+
+// GetFirst returns the value of the first of keys present in the map, trying
+// them in the given order, and whether any was. This suits a variable that has
+// gone by older names: GetFirst("DATABASE_URL", "DB_URL").
+func (m *EnvMap) GetFirst(keys ...string) (string, bool) {
+	for _, key := range keys {
+		if r, ok := m.keys[key]; ok {
+			return m.entries[r].Val, true
+		}
+	}
+	return "", false
+}
+
+// GetFunc returns the value of key as transformed by f, and whether the key
+// is present. This suits normalizing values as they are read, as with
+// strings.TrimSpace or strings.ToLower. f is not called for a missing key.
+func (m *EnvMap) GetFunc(key string, f func(string) string) (string, bool) {
+	val, at := m.Get(key)
+	if at < 0 {
+		return "", false
+	}
+	return f(val), true
+}
+
+// GetSlice splits the value of key on sep, trimming whitespace around each
+// element and dropping empty ones, so that "a, b,,c," gives a, b and c.
+// A missing key gives nil.
+func (m *EnvMap) GetSlice(key, sep string) []string {
+	val, at := m.Get(key)
+	if at < 0 {
+		return nil
+	}
+	var r []string
+	for _, elem := range strings.Split(val, sep) {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			r = append(r, elem)
+		}
+	}
+	return r
+}
+
+// GetSliceDefault is like GetSlice, but returns def when the key is missing
+// or holds no elements.
+func (m *EnvMap) GetSliceDefault(key, sep string, def []string) []string {
+	if r := m.GetSlice(key, sep); len(r) > 0 {
+		return r
+	}
+	return def
+}
+
+// GetDuration parses the value of key as a time.Duration, like 30s or 1h15m.
+// It fails if the key is missing or the value malformed.
+func (m *EnvMap) GetDuration(key string) (time.Duration, error) {
+	val, at := m.Get(key)
+	if at < 0 {
+		return 0, fmt.Errorf("key %s not present", key)
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("%s=%q: %w", key, val, err)
+	}
+	return d, nil
+}
+
+// GetDurationDefault is like GetDuration, but returns def when the key is
+// missing or the value malformed.
+func (m *EnvMap) GetDurationDefault(key string, def time.Duration) time.Duration {
+	if d, err := m.GetDuration(key); err == nil {
+		return d
+	}
+	return def
+}
+
+// GetTime parses the value of key as a time.Time in the given layout, as by
+// time.Parse. It fails if the key is missing or the value malformed.
+func (m *EnvMap) GetTime(key, layout string) (time.Time, error) {
+	val, at := m.Get(key)
+	if at < 0 {
+		return time.Time{}, fmt.Errorf("key %s not present", key)
+	}
+	t, err := time.Parse(layout, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s=%q: %w", key, val, err)
+	}
+	return t, nil
+}
+
+// GetTimeDefault is like GetTime, but returns def when the key is missing or
+// the value malformed.
+func (m *EnvMap) GetTimeDefault(key, layout string, def time.Time) time.Time {
+	if t, err := m.GetTime(key, layout); err == nil {
+		return t
+	}
+	return def
+}
+
 // GetAt returns a key-value pair at the specified position in the map,
 // or an invalid value and negative number if no such index is used.
 //
@@ -117,6 +374,168 @@ func (m *EnvMap) Iter(f func(key, val string)) {
 	}
 }
 
+// IterRaw is like Iter, but passes values as written, with references
+// unexpanded. See Pair.Raw.
+func (m *EnvMap) IterRaw(f func(key, rawVal string)) {
+	for _, p := range m.entries {
+		f(p.Key, p.RawVal())
+	}
+}
+
+// Each is like Iter, but also passes the position of each entry, from 0.
+func (m *EnvMap) Each(f func(i int, key, val string)) {
+	for i, p := range m.entries {
+		f(i, p.Key, p.Val)
+	}
+}
+
+// ForEachError is like Iter, but stops at the first error f returns, and
+// returns it. Whatever f did for the entries before stays done.
+func (m *EnvMap) ForEachError(f func(key, val string) error) error {
+	for _, p := range m.entries {
+		if err := f(p.Key, p.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Swap exchanges the places of two entries, leaving their keys and values
+// as they are. It fails if either key is not present.
+func (m *EnvMap) Swap(keyA, keyB string) error {
+	a, ok := m.keys[keyA]
+	if !ok {
+		return fmt.Errorf("key %s not present", keyA)
+	}
+	b, ok := m.keys[keyB]
+	if !ok {
+		return fmt.Errorf("key %s not present", keyB)
+	}
+	m.entries[a], m.entries[b] = m.entries[b], m.entries[a]
+	m.keys[keyA], m.keys[keyB] = b, a
+	if m.less != nil {
+		m.reindex()
+	}
+	return nil
+}
+
+// Move puts an entry at index to, shifting those in between, and leaving its
+// key and value as they are. Unlike SetAt, to is where the entry ends up; it
+// is clamped to the bounds of the map. It fails if key is not present.
+func (m *EnvMap) Move(key string, to int) error {
+	from, ok := m.keys[key]
+	if !ok {
+		return fmt.Errorf("key %s not present", key)
+	}
+	if to < 0 {
+		to = 0
+	}
+	if to >= len(m.entries) {
+		to = len(m.entries) - 1
+	}
+	p := m.entries[from]
+	if from < to {
+		copy(m.entries[from:to], m.entries[from+1:to+1])
+	} else {
+		copy(m.entries[to+1:from+1], m.entries[to:from])
+	}
+	m.entries[to] = p
+	m.reindex()
+	return nil
+}
+
+// SortByValue reorders the entries by their values, as told by less, keeping
+// the order of entries with values less doesn't tell apart. It has no lasting
+// effect with an order set by SetOrder.
+//
+//	m.SortByValue(func(a, b string) bool { return len(a) > len(b) }) // longest first
+func (m *EnvMap) SortByValue(less func(a, b string) bool) {
+	sort.SliceStable(m.entries, func(i, j int) bool { return less(m.entries[i].Val, m.entries[j].Val) })
+	m.reindex()
+}
+
+// Resolve expands the $NAME and ${NAME} references in all values, as parsing
+// with expansion does, but against the whole map, so that values may also
+// refer to keys that come after them. Names that aren't keys are looked up in
+// the environment. Values are changed in place.
+//
+// Keys whose values refer back to themselves, directly or through others,
+// keep their values as they were, as do keys that refer to them. Such keys
+// are listed in the error. Values recorded as single quoted, as by
+// PreserveQuotes, are kept as they are.
+func (m *EnvMap) Resolve() error {
+	return m.resolve(false)
+}
+
+// resolve is Resolve, with references of keys to themselves, as in
+// PATH=${PATH}:/opt/bin, looked up in the environment if selfFromEnv is set,
+// rather than taken for cycles.
+func (m *EnvMap) resolve(selfFromEnv bool) error {
+	p := newParser(ParseOptions{Expand: true})
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(m.entries))
+
+	// resolve expands the value of key after those it refers to, reporting
+	// whether it could
+	var resolve func(key string) bool
+	resolve = func(key string) bool {
+		switch state[key] {
+		case visiting:
+			return false
+		case visited:
+			_, at := p.envMap.Get(key)
+			return at >= 0
+		}
+		state[key] = visiting
+		at := m.keys[key]
+		val := m.entries[at].Val
+		if m.entries[at].Quote == QuoteSingle {
+			state[key] = visited
+			p.envMap.Set(key, val)
+			return true
+		}
+		ok := true
+		for _, name := range p.references(val) {
+			if name == key && selfFromEnv {
+				continue // not yet in p.envMap, so it is looked up in ENV
+			}
+			if _, present := m.keys[name]; present && !resolve(name) {
+				ok = false
+			}
+		}
+		state[key] = visited
+		if ok {
+			p.envMap.Set(key, p.expandVariables(val))
+		}
+		return ok
+	}
+
+	var cyclic []string
+	for _, pair := range m.entries {
+		if !resolve(pair.Key) {
+			cyclic = append(cyclic, pair.Key)
+		}
+	}
+	for i, pair := range m.entries {
+		if val, at := p.envMap.Get(pair.Key); at >= 0 && val != pair.Val {
+			if pair.Raw == "" {
+				m.entries[i].Raw = pair.Val
+			}
+			m.entries[i].Val = val
+		}
+	}
+	if m.less != nil {
+		m.reindex() // the order may go by the values
+	}
+	if len(cyclic) > 0 {
+		return fmt.Errorf("cyclic references among %s", strings.Join(cyclic, ", "))
+	}
+	return nil
+}
+
 // Remove deletes an entry from the map, returning the old value and index,
 // or an empty and negative index if not present.
 func (m *EnvMap) Remove(key string) (string, int) {
@@ -127,10 +546,7 @@ func (m *EnvMap) Remove(key string) (string, int) {
 		at = r
 		was = m.entries[at].Val
 		m.entries = append(m.entries[:at], m.entries[at+1:]...)
-		m.keys = make(map[string]int)
-		for ix, pair := range m.entries {
-			m.keys[pair.Key] = ix
-		}
+		m.reindex()
 	}
 	return was, at
 }
@@ -139,19 +555,388 @@ func (m *EnvMap) Remove(key string) (string, int) {
 // value and index or emtpy and -1 if index was not valid.
 func (m *EnvMap) RemoveAt(at int) (string, int) {
 	var was string
-	if at < 0 || at > len(m.entries) {
+	if at < 0 || at >= len(m.entries) {
 		return "", -1
 	}
 	pair := m.entries[at]
 	was = pair.Val
 	m.entries = append(m.entries[:at], m.entries[at+1:]...)
-	m.keys = make(map[string]int)
-	for ix, pair := range m.entries {
-		m.keys[pair.Key] = ix
-	}
+	m.reindex()
 	return was, at
 }
 
+// RemoveMatching removes the entries for which pred holds, such as those
+// with a TEMP_ prefix or an empty value, returning how many it removed. It
+// goes over the map just once, where calling Remove for each key would
+// rebuild the index every time.
+func (m *EnvMap) RemoveMatching(pred func(k, v string) bool) int {
+	kept := m.entries[:0]
+	for _, p := range m.entries {
+		if !pred(p.Key, p.Val) {
+			kept = append(kept, p)
+		}
+	}
+	removed := len(m.entries) - len(kept)
+	if removed > 0 {
+		m.entries = kept
+		m.reindex()
+	}
+	return removed
+}
+
+// Truncate keeps the first n entries of the map and removes the rest. It does
+// nothing if there are no more than n.
+func (m *EnvMap) Truncate(n int) {
+	if n >= len(m.entries) {
+		return
+	}
+	if n < 0 {
+		n = 0
+	}
+	for _, p := range m.entries[n:] {
+		delete(m.keys, p.Key)
+	}
+	m.entries = m.entries[:n]
+}
+
+// Reset removes all entries, keeping the memory they took up for those set
+// next, so that a map can be reused, say in a loop, without allocating it
+// anew. An order set by SetOrder is kept too.
+func (m *EnvMap) Reset() {
+	m.entries = m.entries[:0]
+	for k := range m.keys {
+		delete(m.keys, k)
+	}
+}
+
+// Patch applies a batch of changes, in the manner of a JSON merge patch: a
+// nil value removes the key, any other sets the key to the value pointed to.
+// Existing keys keep their place, and new keys are appended in sorted order.
+func (m *EnvMap) Patch(changes map[string]*string) {
+	keys := make([]string, 0, len(changes))
+	for k := range changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v := changes[k]; v != nil {
+			m.Set(k, *v)
+		} else {
+			m.Remove(k)
+		}
+	}
+}
+
+// Map returns a new map with each entry replaced by the key and value f
+// returns for it, in order. If f maps several entries to the same key, the
+// key keeps the place of the first of them and the value of the last.
+func (m *EnvMap) Map(f func(k, v string) (string, string)) *EnvMap {
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		r.Set(f(p.Key, p.Val))
+	}
+	return r
+}
+
+// Pick returns a new map of the entries with the given keys, in order. Keys
+// that aren't present are ignored.
+func (m *EnvMap) Pick(keys ...string) *EnvMap {
+	wanted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		wanted[k] = true
+	}
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		if wanted[p.Key] {
+			r.setPair(p)
+		}
+	}
+	return r
+}
+
+// Pairs returns a copy of the entries, in order. Parsed with expansion, each
+// tells both what its value was written as and what it expanded to:
+//
+//	for _, p := range envMap.Pairs() {
+//		fmt.Printf("%s=%s, from %s\n", p.Key, p.Val, p.RawVal())
+//	}
+func (m *EnvMap) Pairs() []Pair {
+	return append([]Pair(nil), m.entries...)
+}
+
+// Snapshot returns a point-in-time copy of the entries, in order, as Pairs
+// does. Changes to the map after it is taken don't show in the snapshot, so
+// one goroutine can range over it while another goes on changing the map.
+// Taking the snapshot is a read of the map like any other, though, and must
+// not overlap with a change to it.
+func (m *EnvMap) Snapshot() []Pair {
+	return m.Pairs()
+}
+
+// Select returns the entries with the given keys, in the order of keys rather
+// than that of the map. Keys that aren't present are skipped.
+func (m *EnvMap) Select(keys ...string) []Pair {
+	var r []Pair
+	for _, k := range keys {
+		if at, ok := m.keys[k]; ok {
+			r = append(r, m.entries[at])
+		}
+	}
+	return r
+}
+
+// Intersect returns a new map of the entries of m whose keys are also in
+// other, in order.
+func (m *EnvMap) Intersect(other *EnvMap) *EnvMap {
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		if _, at := other.Get(p.Key); at >= 0 {
+			r.setPair(p)
+		}
+	}
+	return r
+}
+
+// Union returns a new map of the entries of m followed by those of other
+// whose keys m lacks. Keys in both keep the value of m, unless override is
+// set, in which case they take the value of other. Neither map is changed.
+func (m *EnvMap) Union(other *EnvMap, override bool) *EnvMap {
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		r.setPair(p)
+	}
+	for _, p := range other.entries {
+		if _, at := r.Get(p.Key); at < 0 || override {
+			r.setPair(p)
+		}
+	}
+	return r
+}
+
+// Partition splits the map in two new maps, of the entries for which pred
+// holds and of the rest, each in order. The map itself is left alone.
+func (m *EnvMap) Partition(pred func(k, v string) bool) (matched, rest *EnvMap) {
+	matched, rest = NewEnvMap(), NewEnvMap()
+	for _, p := range m.entries {
+		if pred(p.Key, p.Val) {
+			matched.setPair(p)
+		} else {
+			rest.setPair(p)
+		}
+	}
+	return matched, rest
+}
+
+// CopyTo sets the entries of m in dst, in order, changing dst in place where
+// Union would make a new map. Keys dst already has keep their place, and their
+// value unless override is set.
+func (m *EnvMap) CopyTo(dst *EnvMap, override bool) {
+	dst.grow(len(m.entries))
+	for _, p := range m.entries {
+		if _, at := dst.Get(p.Key); at < 0 || override {
+			dst.setPair(p)
+		}
+	}
+}
+
+// Compact returns a new map without the entries whose value is empty.
+func (m *EnvMap) Compact() *EnvMap {
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		if p.Val != "" {
+			r.setPair(p)
+		}
+	}
+	return r
+}
+
+// CompactInPlace removes the entries whose value is empty.
+func (m *EnvMap) CompactInPlace() {
+	kept := m.entries[:0]
+	for _, p := range m.entries {
+		if p.Val != "" {
+			kept = append(kept, p)
+		}
+	}
+	m.entries = kept
+	m.reindex()
+}
+
+// GrepTarget selects what Grep matches its pattern against.
+type GrepTarget int
+
+const (
+	GrepKeysAndValues GrepTarget = iota
+	GrepKeys
+	GrepValues
+)
+
+// Grep returns a new map of the entries whose key or value matches the
+// regular expression pattern, in order.
+func (m *EnvMap) Grep(pattern string) (*EnvMap, error) {
+	return m.GrepIn(pattern, GrepKeysAndValues)
+}
+
+// GrepIn is like Grep, but matches only against the keys or only against the
+// values if so told by target.
+func (m *EnvMap) GrepIn(pattern string, target GrepTarget) (*EnvMap, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	r := NewEnvMap()
+	for _, p := range m.entries {
+		if (target != GrepValues && re.MatchString(p.Key)) ||
+			(target != GrepKeys && re.MatchString(p.Val)) {
+			r.setPair(p)
+		}
+	}
+	return r, nil
+}
+
+// FindFunc returns the first entry, in order, for which pred holds, and
+// whether there was one.
+func (m *EnvMap) FindFunc(pred func(k, v string) bool) (Pair, bool) {
+	for _, p := range m.entries {
+		if pred(p.Key, p.Val) {
+			return p, true
+		}
+	}
+	return Pair{}, false
+}
+
+// Keys returns the keys of the map, in order.
+func (m *EnvMap) Keys() []string {
+	keys := make([]string, len(m.entries))
+	for i, p := range m.entries {
+		keys[i] = p.Key
+	}
+	return keys
+}
+
+// ToSlice returns the entries as KEY=value strings, in order, the form of
+// os.Environ and exec.Cmd.Env. Values are not quoted or escaped. The slice is
+// never nil, so that setting it as the Env of a command gives it exactly
+// these variables, even if there are none.
+func (m *EnvMap) ToSlice() []string {
+	env := make([]string, len(m.entries))
+	for i, p := range m.entries {
+		env[i] = p.Key + "=" + p.Val
+	}
+	return env
+}
+
+// KeysMatching returns the keys that match the regular expression pattern,
+// sorted rather than in order.
+func (m *EnvMap) KeysMatching(pattern string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, p := range m.entries {
+		if re.MatchString(p.Key) {
+			keys = append(keys, p.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DiffString describes how b differs from m in the manner of a unified diff,
+// one line per entry: " KEY=val" for unchanged entries, "-KEY=val" for entries
+// only in m and "+KEY=val" for those only in b. A changed value gives a "-"
+// line for the old value followed by a "+" line for the new one.
+//
+// Entries follow the order of m, with those new in b appended in their order.
+func (m *EnvMap) DiffString(b *EnvMap) string {
+	return m.diffString(b, func(v string) string { return v })
+}
+
+// RedactedDiffString is like DiffString, but with all values masked, so that
+// the report can be shared without leaking secrets.
+func (m *EnvMap) RedactedDiffString(b *EnvMap) string {
+	return m.diffString(b, func(string) string { return "***" })
+}
+
+func (m *EnvMap) diffString(b *EnvMap, show func(v string) string) string {
+	var buf bytes.Buffer
+	line := func(op byte, k, v string) {
+		buf.WriteByte(op)
+		buf.WriteString(k + "=" + show(v) + "\n")
+	}
+	for _, p := range m.entries {
+		v, at := b.Get(p.Key)
+		switch {
+		case at < 0:
+			line('-', p.Key, p.Val)
+		case v != p.Val:
+			line('-', p.Key, p.Val)
+			line('+', p.Key, v)
+		default:
+			line(' ', p.Key, p.Val)
+		}
+	}
+	for _, p := range b.entries {
+		if _, at := m.Get(p.Key); at < 0 {
+			line('+', p.Key, p.Val)
+		}
+	}
+	return buf.String()
+}
+
+// HashSum returns a hex encoded SHA-256 of the keys and values in the map.
+// It doesn't depend on the order of the entries, so that it changes only when
+// the content does.
+func (m *EnvMap) HashSum() string {
+	h := sha256.New()
+	for _, p := range m.sorted() {
+		// NUL can't occur in the environment, so the pairs can't run together
+		io.WriteString(h, p.Key+"\x00"+p.Val+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Compare orders maps by their keys and values, whatever the order of their
+// entries: it returns -1, 0 or 1 as m sorts before, with or after other. The
+// entries of each, sorted by key, are compared one by one, key first, and a
+// map that runs out of entries first sorts first. This suits sort.Slice:
+//
+//	sort.Slice(maps, func(i, j int) bool { return maps[i].Compare(maps[j]) < 0 })
+func (m *EnvMap) Compare(other *EnvMap) int {
+	a, b := m.sorted(), other.sorted()
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[i].Val, b[i].Val); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// Equal tells whether m and other hold the same keys with the same values,
+// whatever the order of their entries. It agrees with Compare.
+func (m *EnvMap) Equal(other *EnvMap) bool {
+	return m.Compare(other) == 0
+}
+
+// sorted returns a copy of the entries, sorted by key.
+func (m *EnvMap) sorted() []Pair {
+	sorted := make([]Pair, len(m.entries))
+	copy(sorted, m.entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
 // Emits the contents of the map to the writer, optionally with line numbers.
 func (m *EnvMap) Emit(w io.Writer, linenos bool) {
 	form := formatIx(len(m.entries))
@@ -166,6 +951,15 @@ func (m *EnvMap) Emit(w io.Writer, linenos bool) {
 	}
 }
 
+// EmitNUL writes the contents of the map as KEY=value entries, each ended by
+// a NUL byte, as env -0 prints them and xargs -0 reads them. Values are
+// written as they are, since they can't run into the next entry.
+func (m *EnvMap) EmitNUL(w io.Writer) {
+	m.Export(w, func(ix int, k, v string) string {
+		return k + "=" + v + "\x00"
+	})
+}
+
 // Export calls linefilter for each key-value pair in the set and writes the result to writer.
 func (m *EnvMap) Export(w io.Writer, linefilter func(i int, k, v string) string) {
 	var buf bytes.Buffer
@@ -175,6 +969,40 @@ func (m *EnvMap) Export(w io.Writer, linefilter func(i int, k, v string) string)
 	w.Write(buf.Bytes())
 }
 
+// ExportString is like Export, but returns what it would write.
+func (m *EnvMap) ExportString(linefilter func(i int, k, v string) string) string {
+	var buf bytes.Buffer
+	m.Export(&buf, linefilter)
+	return buf.String()
+}
+
+// grow makes room for n more entries.
+func (m *EnvMap) grow(n int) {
+	if cap(m.entries)-len(m.entries) >= n {
+		return
+	}
+	entries := make([]Pair, len(m.entries), len(m.entries)+n)
+	copy(entries, m.entries)
+	m.entries = entries
+	keys := make(map[string]int, len(m.entries)+n)
+	for k, ix := range m.keys {
+		keys[k] = ix
+	}
+	m.keys = keys
+}
+
+// reindex rebuilds the key index after entries have moved, sorting them first
+// if an order is set.
+func (m *EnvMap) reindex() {
+	if m.less != nil {
+		sort.SliceStable(m.entries, func(i, j int) bool { return m.less(m.entries[i], m.entries[j]) })
+	}
+	m.keys = make(map[string]int, len(m.entries))
+	for ix, pair := range m.entries {
+		m.keys[pair.Key] = ix
+	}
+}
+
 func formatIx(max int) string {
 	n := int(math.Log10(float64(max))) + 1
 	f := "%0" + fmt.Sprintf("%d", n) + "d "
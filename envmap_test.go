@@ -1,7 +1,15 @@
 package godotenv
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEnvMap(t *testing.T) {
@@ -77,6 +85,138 @@ func TestEnvMap(t *testing.T) {
 
 }
 
+func TestEnvMapGetExpanded(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOME", "/home/me")
+	m, err := Parse(strings.NewReader("URL=http://${HOST}:$PORT/\nHOST=localhost\nPORT=80\nDIR=$HOME/app\nSELF=${SELF}x"), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct{ key, expanded string }{
+		{"URL", "http://localhost:80/"},
+		{"DIR", "/home/me/app"},
+		{"SELF", "${SELF}x"},
+	}
+	for _, tt := range tests {
+		if v, ok := m.GetExpanded(tt.key); !ok || v != tt.expanded {
+			t.Errorf("Failed get expanded %s: %q %v", tt.key, v, ok)
+		}
+	}
+	if v, _ := m.Get("URL"); v != "http://${HOST}:$PORT/" {
+		t.Errorf("Failed get expanded: Get returned %q", v)
+	}
+	if _, ok := m.GetExpanded("MISSING"); ok {
+		t.Errorf("Failed get expanded of a missing key")
+	}
+
+	os.Setenv("HOME_X", "/h")
+	m, err = ParseWithOptions(strings.NewReader("A='$HOME_X'\nB=$HOME_X"), ParseOptions{Expand: true, PreserveQuotes: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v, _ := m.GetExpanded("A"); v != "$HOME_X" {
+		t.Errorf("Expected a single-quoted value to stay literal, got %q", v)
+	}
+	if v, _ := m.GetExpanded("B"); v != "/h" {
+		t.Errorf("Failed get expanded B: %q", v)
+	}
+}
+
+func TestEnvMapWith(t *testing.T) {
+	m := NewEnvMap().With("B", "1").With("A", "2").With("B", "3")
+	expected := []Pair{{Key: "B", Val: "3"}, {Key: "A", Val: "2"}}
+	if !reflect.DeepEqual(m.entries, expected) {
+		t.Errorf("Failed with: %v", m.entries)
+	}
+}
+
+func TestEnvMapPrepend(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	if old, at := m.Prepend("z", "Z"); old != "" || at != -1 {
+		t.Errorf("Failed prepend new key")
+	}
+	if old, at := m.Prepend("b", "BB"); old != "B" || at != 2 {
+		t.Errorf("Failed prepend existing key: %s %d", old, at)
+	}
+	expected := []Pair{{Key: "b", Val: "BB"}, {Key: "z", Val: "Z"}, {Key: "a", Val: "A"}, {Key: "c", Val: "C"}}
+	if !reflect.DeepEqual(m.entries, expected) {
+		t.Errorf("Failed prepend order: %v", m.entries)
+	}
+	for i, p := range expected {
+		if _, at := m.Get(p.Key); at != i {
+			t.Errorf("Failed prepend index of %s: %d", p.Key, at)
+		}
+	}
+
+	// moving the last key must not leave it behind
+	m.Prepend("c", "C")
+	if m.Len() != 4 || m.entries[0].Key != "c" || m.entries[3].Key != "a" {
+		t.Errorf("Failed prepend last key: %v", m.entries)
+	}
+}
+
+func TestEnvMapTruncate(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	m.Truncate(5)
+	if m.Len() != 3 {
+		t.Errorf("Failed truncate beyond length")
+	}
+	m.Truncate(2)
+	if !reflect.DeepEqual(m.Keys(), []string{"a", "b"}) {
+		t.Errorf("Failed truncate: %v", m.Keys())
+	}
+	if _, at := m.Get("c"); at != -1 {
+		t.Errorf("Failed truncate index")
+	}
+	m.Set("c", "CC")
+	if _, at := m.Get("c"); at != 2 {
+		t.Errorf("Failed set after truncate: %d", at)
+	}
+	m.Truncate(0)
+	if m.Len() != 0 {
+		t.Errorf("Failed truncate to nothing")
+	}
+
+	if _, at := NewEnvMap().RemoveAt(0); at != -1 {
+		t.Errorf("Failed remove at length")
+	}
+}
+
+func TestEnvMapRemoveMatching(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("TEMP_A", "1")
+	m.Set("HOST", "h")
+	m.Set("TEMP_B", "2")
+	m.Set("EMPTY", "")
+	m.Set("PORT", "80")
+
+	n := m.RemoveMatching(func(k, v string) bool { return strings.HasPrefix(k, "TEMP_") || v == "" })
+	if n != 3 {
+		t.Errorf("Expected 3 removed, got %d", n)
+	}
+	if !reflect.DeepEqual(m.Keys(), []string{"HOST", "PORT"}) {
+		t.Errorf("Failed remove matching: %v", m.Keys())
+	}
+	if _, at := m.Get("PORT"); at != 1 {
+		t.Errorf("Failed remove matching index: %d", at)
+	}
+	if _, at := m.Get("TEMP_B"); at != -1 {
+		t.Errorf("Failed remove matching index of removed key: %d", at)
+	}
+	if n := m.RemoveMatching(func(k, v string) bool { return false }); n != 0 || m.Len() != 2 {
+		t.Errorf("Failed remove matching nothing: %d", n)
+	}
+}
+
 func TestEnvMapIter(t *testing.T) {
 	m := NewEnvMap()
 	m.Set("0", "A")
@@ -90,3 +230,870 @@ func TestEnvMapIter(t *testing.T) {
 	}
 	// TBD
 }
+
+func TestEnvMapEach(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+	m.Remove("b")
+
+	var seen []string
+	m.Each(func(i int, k, v string) {
+		seen = append(seen, fmt.Sprintf("%d:%s=%s", i, k, v))
+	})
+	if !reflect.DeepEqual(seen, []string{"0:a=A", "1:c=C"}) {
+		t.Errorf("Failed each: %v", seen)
+	}
+}
+
+func TestEnvMapForEachError(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "")
+	m.Set("c", "C")
+
+	var seen []string
+	errEmpty := errors.New("empty")
+	err := m.ForEachError(func(k, v string) error {
+		seen = append(seen, k)
+		if v == "" {
+			return errEmpty
+		}
+		return nil
+	})
+	if err != errEmpty || !reflect.DeepEqual(seen, []string{"a", "b"}) {
+		t.Errorf("Failed for each error stopping: %v %v", err, seen)
+	}
+
+	seen = nil
+	err = m.ForEachError(func(k, v string) error {
+		seen = append(seen, k)
+		return nil
+	})
+	if err != nil || len(seen) != 3 {
+		t.Errorf("Failed for each error: %v %v", err, seen)
+	}
+}
+
+func TestEnvMapSwap(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	if err := m.Swap("a", "c"); err != nil {
+		t.Fatalf("Failed swap: %v", err)
+	}
+	expected := []Pair{{Key: "c", Val: "C"}, {Key: "b", Val: "B"}, {Key: "a", Val: "A"}}
+	if !reflect.DeepEqual(m.entries, expected) {
+		t.Errorf("Failed swap order: %v", m.entries)
+	}
+	if v, at := m.Get("a"); v != "A" || at != 2 {
+		t.Errorf("Failed swap index: %s %d", v, at)
+	}
+	if v, at := m.Get("c"); v != "C" || at != 0 {
+		t.Errorf("Failed swap index: %s %d", v, at)
+	}
+
+	if err := m.Swap("a", "a"); err != nil {
+		t.Errorf("Failed swap with itself: %v", err)
+	}
+	if err := m.Swap("a", "missing"); err == nil {
+		t.Errorf("Failed swap with missing key")
+	}
+	if v, at := m.Get("a"); v != "A" || at != 2 {
+		t.Errorf("Failed swap with missing key left map alone: %s %d", v, at)
+	}
+}
+
+func TestEnvMapGrep(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("DB_HOST", "localhost")
+	m.Set("CACHE_HOST", "db.cache")
+	m.Set("PORT", "5432")
+	m.Set("DB_NAME", "app")
+
+	r, err := m.Grep("^DB|^db")
+	if err != nil {
+		t.Fatalf("Failed grep: %v", err)
+	}
+	if r.Len() != 3 {
+		t.Errorf("Failed grep len")
+	}
+	if p, ok, _ := r.GetAt(1); !ok || p.Key != "CACHE_HOST" {
+		t.Errorf("Failed grep order")
+	}
+
+	r, _ = m.GrepIn("^DB|^db", GrepKeys)
+	if _, at := r.Get("CACHE_HOST"); r.Len() != 2 || at >= 0 {
+		t.Errorf("Failed grep keys")
+	}
+	r, _ = m.GrepIn("^DB|^db", GrepValues)
+	if _, at := r.Get("CACHE_HOST"); r.Len() != 1 || at != 0 {
+		t.Errorf("Failed grep values")
+	}
+
+	if _, err = m.Grep("("); err == nil {
+		t.Errorf("Expected grep to fail on an invalid pattern")
+	}
+}
+
+func TestEnvMapFindFunc(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("HOST", "localhost")
+	m.Set("DB_URL", "postgres://db/app")
+	m.Set("CACHE_URL", "postgres://cache/app")
+
+	hasPrefix := func(prefix string) func(k, v string) bool {
+		return func(k, v string) bool { return strings.HasPrefix(v, prefix) }
+	}
+	if p, ok := m.FindFunc(hasPrefix("postgres:")); !ok || p.Key != "DB_URL" {
+		t.Errorf("Failed find: %v %v", p, ok)
+	}
+	if p, ok := m.FindFunc(hasPrefix("redis:")); ok || p != (Pair{}) {
+		t.Errorf("Failed find without a match: %v %v", p, ok)
+	}
+}
+
+func TestEnvMapSetOrder(t *testing.T) {
+	group := func(k string) string { return strings.SplitN(k, "_", 2)[0] }
+	byPrefix := func(a, b Pair) bool {
+		if ga, gb := group(a.Key), group(b.Key); ga != gb {
+			return ga < gb
+		}
+		return a.Key < b.Key
+	}
+
+	m := NewEnvMap()
+	m.Set("DB_PORT", "5432")
+	m.Set("APP_NAME", "app")
+	m.Set("DB_HOST", "db")
+	m.SetOrder(byPrefix)
+	if order := strings.Join(m.Keys(), ","); order != "APP_NAME,DB_HOST,DB_PORT" {
+		t.Errorf("Failed set order: %s", order)
+	}
+
+	m.Set("CACHE_URL", "redis://")
+	m.Set("APP_DEBUG", "1")
+	m.Prepend("DB_USER", "admin")
+	if err := m.Move("APP_DEBUG", 4); err != nil {
+		t.Fatal(err)
+	}
+	m.Remove("DB_HOST")
+	expected := "APP_DEBUG,APP_NAME,CACHE_URL,DB_PORT,DB_USER"
+	if order := strings.Join(m.Keys(), ","); order != expected {
+		t.Errorf("Failed set order after changes: %s", order)
+	}
+	for i, p := range m.entries {
+		if _, at := m.Get(p.Key); at != i {
+			t.Errorf("Failed set order index of %s: %d", p.Key, at)
+		}
+	}
+	if got := Marshal(m); !strings.HasPrefix(got, "APP_DEBUG=\"1\"\nAPP_NAME=") {
+		t.Errorf("Failed set order marshal: %q", got)
+	}
+
+	m.SetOrder(nil)
+	m.Set("B", "b")
+	if order := strings.Join(m.Keys(), ","); order != expected+",B" {
+		t.Errorf("Failed unset order: %s", order)
+	}
+}
+
+func TestEnvMapToSlice(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("B", "1")
+	m.Set("A", "x=y \"quoted\"")
+	m.Set("EMPTY", "")
+
+	expected := []string{"B=1", `A=x=y "quoted"`, "EMPTY="}
+	if env := m.ToSlice(); !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected %q, got %q", expected, env)
+	}
+	if env := NewEnvMap().ToSlice(); env == nil || len(env) != 0 {
+		t.Errorf("Failed empty slice: %#v", env)
+	}
+}
+
+func TestEnvMapKeys(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("DB_USER", "u")
+	m.Set("APP_NAME", "n")
+	m.Set("DB_HOST", "h")
+
+	if keys := m.Keys(); !reflect.DeepEqual(keys, []string{"DB_USER", "APP_NAME", "DB_HOST"}) {
+		t.Errorf("Failed keys: %v", keys)
+	}
+	keys, err := m.KeysMatching("^DB_")
+	if err != nil || !reflect.DeepEqual(keys, []string{"DB_HOST", "DB_USER"}) {
+		t.Errorf("Failed keys matching: %v %v", keys, err)
+	}
+	if keys, err := m.KeysMatching("^NONE"); err != nil || len(keys) != 0 {
+		t.Errorf("Failed keys matching nothing: %v %v", keys, err)
+	}
+	if _, err := m.KeysMatching("("); err == nil {
+		t.Errorf("Failed keys matching bad pattern")
+	}
+}
+
+func TestEnvMapPartition(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("API_TOKEN", "t")
+	m.Set("HOST", "h")
+	m.Set("DB_SECRET", "s")
+	m.Set("PORT", "p")
+
+	secrets, public := m.Partition(func(k, v string) bool {
+		return strings.Contains(k, "SECRET") || strings.Contains(k, "TOKEN")
+	})
+	if keys := secrets.Keys(); !reflect.DeepEqual(keys, []string{"API_TOKEN", "DB_SECRET"}) {
+		t.Errorf("Failed partition matched: %v", keys)
+	}
+	if keys := public.Keys(); !reflect.DeepEqual(keys, []string{"HOST", "PORT"}) {
+		t.Errorf("Failed partition rest: %v", keys)
+	}
+	if v, at := public.Get("PORT"); v != "p" || at != 1 {
+		t.Errorf("Failed partition index: %s %d", v, at)
+	}
+	if m.Len() != 4 || m.entries[2].Key != "DB_SECRET" {
+		t.Errorf("Failed partition leaving the map alone: %v", m.entries)
+	}
+}
+
+func TestEnvMapCompact(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "")
+	m.Set("c", "C")
+	m.Set("d", "")
+
+	r := m.Compact()
+	if r.Len() != 2 || m.Len() != 4 {
+		t.Errorf("Failed compact")
+	}
+	if k, at := r.Get("c"); k != "C" || at != 1 {
+		t.Errorf("Failed compact order")
+	}
+
+	m.CompactInPlace()
+	if m.Len() != 2 {
+		t.Errorf("Failed compact in place")
+	}
+	if k, at := m.Get("c"); k != "C" || at != 1 {
+		t.Errorf("Failed compact in place index")
+	}
+	if _, at := m.Get("b"); at != -1 {
+		t.Errorf("Failed compact in place removal")
+	}
+}
+
+func TestEnvMapPick(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	r := m.Pick("c", "a", "x")
+	if r.Len() != 2 {
+		t.Errorf("Failed pick len")
+	}
+	if k, at := r.Get("a"); k != "A" || at != 0 {
+		t.Errorf("Failed pick order")
+	}
+	if r = m.Pick(); r.Len() != 0 {
+		t.Errorf("Failed empty pick")
+	}
+}
+
+func TestEnvMapPairs(t *testing.T) {
+	envMap, err := Unmarshal("A=x\nB=\"${A}y\"\nC='$A'")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pairs := envMap.Pairs()
+	expected := []struct{ val, raw string }{{"x", "x"}, {"xy", "${A}y"}, {"$A", "$A"}}
+	if len(pairs) != len(expected) {
+		t.Fatalf("Failed pairs: %v", pairs)
+	}
+	for i, e := range expected {
+		if pairs[i].Val != e.val || pairs[i].RawVal() != e.raw {
+			t.Errorf("Failed pair %s: expected %q from %q, got %q from %q", pairs[i].Key, e.val, e.raw, pairs[i].Val, pairs[i].RawVal())
+		}
+	}
+
+	pairs[0].Val = "changed"
+	if v, _ := envMap.Get("A"); v != "x" {
+		t.Errorf("Failed pairs copy: %s", v)
+	}
+}
+
+func TestEnvMapSnapshot(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("A", "1")
+	m.Set("B", "2")
+
+	snapshot := m.Snapshot()
+	m.Set("A", "changed")
+	m.Remove("B")
+	m.Set("C", "3")
+
+	expected := []Pair{{Key: "A", Val: "1"}, {Key: "B", Val: "2"}}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("Expected %v, got %v", expected, snapshot)
+	}
+}
+
+func TestEnvMapSelect(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	r := m.Select("c", "x", "a")
+	expected := []Pair{{Key: "c", Val: "C"}, {Key: "a", Val: "A"}}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Failed select: %v", r)
+	}
+	if r = m.Select(); len(r) != 0 {
+		t.Errorf("Failed empty select")
+	}
+}
+
+func TestEnvMapMap(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("APP_A", "1")
+	m.Set("b", "2")
+	m.Set("A", "3")
+
+	r := m.Map(func(k, v string) (string, string) {
+		return strings.ToLower(strings.TrimPrefix(k, "APP_")), v + v
+	})
+	if r.Len() != 2 || m.Len() != 3 {
+		t.Errorf("Failed map len")
+	}
+	if k, at := r.Get("a"); k != "33" || at != 0 {
+		t.Errorf("Failed map collision")
+	}
+	if k, at := r.Get("b"); k != "22" || at != 1 {
+		t.Errorf("Failed map")
+	}
+}
+
+func TestEnvMapPatch(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	m.Set("c", "C")
+
+	z, y, c := "Z", "Y", "CC"
+	m.Patch(map[string]*string{"b": nil, "z": &z, "y": &y, "c": &c, "x": nil})
+
+	expected := []Pair{{Key: "a", Val: "A"}, {Key: "c", Val: "CC"}, {Key: "y", Val: "Y"}, {Key: "z", Val: "Z"}}
+	if m.Len() != len(expected) {
+		t.Fatalf("Failed patch len")
+	}
+	for i, e := range expected {
+		if p, _, _ := m.GetAt(i); p != e {
+			t.Errorf("Failed patch at %d: %v", i, p)
+		}
+		if _, at := m.Get(e.Key); at != i {
+			t.Errorf("Failed patch index for %s", e.Key)
+		}
+	}
+}
+
+func TestEnvMapDiffString(t *testing.T) {
+	a := NewEnvMap()
+	a.Set("SAME", "1")
+	a.Set("GONE", "2")
+	a.Set("CHANGED", "old")
+	b := NewEnvMap()
+	b.Set("NEW", "3")
+	b.Set("CHANGED", "new")
+	b.Set("SAME", "1")
+
+	expected := " SAME=1\n-GONE=2\n-CHANGED=old\n+CHANGED=new\n+NEW=3\n"
+	if d := a.DiffString(b); d != expected {
+		t.Errorf("Expected diff %q, got %q", expected, d)
+	}
+
+	expected = " SAME=***\n-GONE=***\n-CHANGED=***\n+CHANGED=***\n+NEW=***\n"
+	if d := a.RedactedDiffString(b); d != expected {
+		t.Errorf("Expected redacted diff %q, got %q", expected, d)
+	}
+
+	if d := a.DiffString(a); strings.ContainsAny(d, "+-") {
+		t.Errorf("Expected no changes diffing a map with itself, got %q", d)
+	}
+}
+
+func TestEnvMapGetSlice(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("HOSTS", " a, b,,c ,")
+	m.Set("EMPTY", " , ")
+
+	if r := m.GetSlice("HOSTS", ","); !reflect.DeepEqual(r, []string{"a", "b", "c"}) {
+		t.Errorf("Failed get slice: %q", r)
+	}
+	if r := m.GetSlice("EMPTY", ","); len(r) != 0 {
+		t.Errorf("Failed get empty slice: %q", r)
+	}
+	if r := m.GetSlice("MISSING", ","); r != nil {
+		t.Errorf("Failed get missing slice: %q", r)
+	}
+
+	def := []string{"localhost"}
+	if r := m.GetSliceDefault("HOSTS", ",", def); len(r) != 3 {
+		t.Errorf("Failed get slice default: %q", r)
+	}
+	if r := m.GetSliceDefault("EMPTY", ",", def); !reflect.DeepEqual(r, def) {
+		t.Errorf("Failed get empty slice default: %q", r)
+	}
+	if r := m.GetSliceDefault("MISSING", ",", def); !reflect.DeepEqual(r, def) {
+		t.Errorf("Failed get missing slice default: %q", r)
+	}
+}
+
+func TestEnvMapGetFirst(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("DB_URL", "old")
+	m.Set("POSTGRES_URL", "older")
+	m.Set("EMPTY", "")
+
+	if v, ok := m.GetFirst("DATABASE_URL", "DB_URL", "POSTGRES_URL"); !ok || v != "old" {
+		t.Errorf("Failed get first: %q %v", v, ok)
+	}
+	if v, ok := m.GetFirst("EMPTY", "DB_URL"); !ok || v != "" {
+		t.Errorf("Failed get first empty value: %q %v", v, ok)
+	}
+	if v, ok := m.GetFirst("DATABASE_URL", "MISSING"); ok || v != "" {
+		t.Errorf("Failed get first missing: %q %v", v, ok)
+	}
+	if _, ok := m.GetFirst(); ok {
+		t.Errorf("Failed get first of no keys")
+	}
+}
+
+func TestEnvMapGetFunc(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("PADDED", "  value \t")
+
+	if v, ok := m.GetFunc("PADDED", strings.TrimSpace); !ok || v != "value" {
+		t.Errorf("Failed get func: %q %v", v, ok)
+	}
+	called := false
+	v, ok := m.GetFunc("MISSING", func(s string) string { called = true; return s })
+	if ok || v != "" || called {
+		t.Errorf("Failed get func missing: %q %v %v", v, ok, called)
+	}
+}
+
+func TestEnvMapGetDurationTime(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("TIMEOUT", "1m30s")
+	m.Set("START", "2024-03-01T12:00:00Z")
+	m.Set("BAD", "soon")
+
+	if d, err := m.GetDuration("TIMEOUT"); err != nil || d != 90*time.Second {
+		t.Errorf("Failed get duration: %v %v", d, err)
+	}
+	if _, err := m.GetDuration("BAD"); err == nil || !strings.Contains(err.Error(), `BAD="soon"`) {
+		t.Errorf("Failed get malformed duration: %v", err)
+	}
+	if _, err := m.GetDuration("MISSING"); err == nil || !strings.Contains(err.Error(), "MISSING") {
+		t.Errorf("Failed get missing duration: %v", err)
+	}
+	if d := m.GetDurationDefault("TIMEOUT", time.Second); d != 90*time.Second {
+		t.Errorf("Failed get duration default: %v", d)
+	}
+	if d := m.GetDurationDefault("BAD", time.Second); d != time.Second {
+		t.Errorf("Failed get malformed duration default: %v", d)
+	}
+	if d := m.GetDurationDefault("MISSING", time.Second); d != time.Second {
+		t.Errorf("Failed get missing duration default: %v", d)
+	}
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if v, err := m.GetTime("START", time.RFC3339); err != nil || !v.Equal(start) {
+		t.Errorf("Failed get time: %v %v", v, err)
+	}
+	if _, err := m.GetTime("BAD", time.RFC3339); err == nil || !strings.Contains(err.Error(), `BAD="soon"`) {
+		t.Errorf("Failed get malformed time: %v", err)
+	}
+	if _, err := m.GetTime("MISSING", time.RFC3339); err == nil {
+		t.Errorf("Failed get missing time")
+	}
+	def := time.Unix(0, 0)
+	if v := m.GetTimeDefault("START", time.RFC3339, def); !v.Equal(start) {
+		t.Errorf("Failed get time default: %v", v)
+	}
+	if v := m.GetTimeDefault("BAD", time.RFC3339, def); !v.Equal(def) {
+		t.Errorf("Failed get malformed time default: %v", v)
+	}
+}
+
+func TestEnvMapIntersectUnion(t *testing.T) {
+	a := NewEnvMap()
+	a.Set("a", "A")
+	a.Set("b", "B")
+	a.Set("c", "C")
+	b := NewEnvMap()
+	b.Set("d", "D")
+	b.Set("c", "CC")
+	b.Set("a", "AA")
+
+	r := a.Intersect(b)
+	if r.Len() != 2 {
+		t.Errorf("Failed intersect len")
+	}
+	if k, at := r.Get("a"); k != "A" || at != 0 {
+		t.Errorf("Failed intersect 'a'")
+	}
+	if k, at := r.Get("c"); k != "C" || at != 1 {
+		t.Errorf("Failed intersect 'c'")
+	}
+
+	r = a.Union(b, false)
+	if r.Len() != 4 || a.Len() != 3 || b.Len() != 3 {
+		t.Errorf("Failed union len")
+	}
+	if k, at := r.Get("c"); k != "C" || at != 2 {
+		t.Errorf("Failed union 'c'")
+	}
+	if k, at := r.Get("d"); k != "D" || at != 3 {
+		t.Errorf("Failed union 'd'")
+	}
+
+	r = a.Union(b, true)
+	if k, at := r.Get("c"); k != "CC" || at != 2 {
+		t.Errorf("Failed union override 'c'")
+	}
+	if k, _ := a.Get("c"); k != "C" {
+		t.Errorf("Failed union left receiver changed")
+	}
+}
+
+func TestEnvMapCopyTo(t *testing.T) {
+	src := NewEnvMap()
+	src.Set("c", "C")
+	src.Set("a", "AA")
+	src.Set("d", "D")
+
+	for _, override := range []bool{false, true} {
+		dst := NewEnvMap()
+		dst.Set("a", "A")
+		dst.Set("b", "B")
+		src.CopyTo(dst, override)
+
+		a := "A"
+		if override {
+			a = "AA"
+		}
+		expected := []Pair{{Key: "a", Val: a}, {Key: "b", Val: "B"}, {Key: "c", Val: "C"}, {Key: "d", Val: "D"}}
+		if !reflect.DeepEqual(dst.entries, expected) {
+			t.Errorf("Failed copy to with override %v: %v", override, dst.entries)
+		}
+		if _, at := dst.Get("d"); at != 3 {
+			t.Errorf("Failed copy to index: %d", at)
+		}
+	}
+	if src.Len() != 3 {
+		t.Errorf("Failed copy to leaving the source alone")
+	}
+}
+
+func TestEnvMapSetIfAbsent(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+
+	if m.SetIfAbsent("a", "AA") {
+		t.Errorf("Failed set if absent on present key")
+	}
+	if k, _ := m.Get("a"); k != "A" {
+		t.Errorf("Failed set if absent kept value")
+	}
+	if !m.SetIfAbsent("b", "B") {
+		t.Errorf("Failed set if absent on absent key")
+	}
+	if k, at := m.Get("b"); k != "B" || at != 1 {
+		t.Errorf("Failed set if absent set value")
+	}
+}
+
+func TestNewEnvMapCap(t *testing.T) {
+	m := NewEnvMapCap(10)
+	if m.Len() != 0 {
+		t.Errorf("Failed new with capacity len")
+	}
+	m.Set("a", "A")
+	m.grow(100)
+	m.Set("b", "B")
+	if k, at := m.Get("a"); k != "A" || at != 0 {
+		t.Errorf("Failed grow kept 'a'")
+	}
+	if k, at := m.Get("b"); k != "B" || at != 1 {
+		t.Errorf("Failed grow 'b'")
+	}
+}
+
+func TestFromMapOrdered(t *testing.T) {
+	src := map[string]string{"d": "D", "b": "B", "a": "A", "c": "C"}
+
+	keys := func(m *EnvMap) []string {
+		var r []string
+		m.Iter(func(k, v string) { r = append(r, k) })
+		return r
+	}
+
+	if k := keys(FromMap(src)); !reflect.DeepEqual(k, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Failed from map order: %v", k)
+	}
+
+	m := FromMapOrdered(src, []string{"c", "x", "a"})
+	if k := keys(m); !reflect.DeepEqual(k, []string{"c", "a", "b", "d"}) {
+		t.Errorf("Failed from map ordered: %v", k)
+	}
+	if v, _ := m.Get("c"); v != "C" {
+		t.Errorf("Failed from map ordered value")
+	}
+}
+
+func TestEnvMapHashSum(t *testing.T) {
+	a := NewEnvMap()
+	a.Set("a", "A")
+	a.Set("b", "B")
+	b := NewEnvMap()
+	b.Set("b", "B")
+	b.Set("a", "A")
+
+	if a.HashSum() != b.HashSum() {
+		t.Errorf("Failed hash sum independent of order")
+	}
+	if len(a.HashSum()) != 64 {
+		t.Errorf("Failed hash sum length: %s", a.HashSum())
+	}
+	b.Set("a", "AA")
+	if a.HashSum() == b.HashSum() {
+		t.Errorf("Failed hash sum changed value")
+	}
+
+	c := NewEnvMap()
+	c.Set("a", "A\x00b")
+	d := NewEnvMap()
+	d.Set("a", "A")
+	d.Set("b", "")
+	if NewEnvMap().HashSum() == d.HashSum() || c.HashSum() == d.HashSum() {
+		t.Errorf("Failed hash sum distinct content")
+	}
+}
+
+func TestEnvMapCompare(t *testing.T) {
+	parse := func(s string) *EnvMap {
+		m, _ := Unmarshal(s)
+		return m
+	}
+	maps := []*EnvMap{parse("b=1"), parse("a=2\nb=1"), parse("a=1"), parse(""), parse("b=1\na=1")}
+	sort.Slice(maps, func(i, j int) bool { return maps[i].Compare(maps[j]) < 0 })
+
+	expected := []*EnvMap{parse(""), parse("a=1"), parse("a=1\nb=1"), parse("a=2\nb=1"), parse("b=1")}
+	for i := range expected {
+		if !maps[i].Equal(expected[i]) {
+			t.Errorf("Failed compare order at %d: %v", i, maps[i].Keys())
+		}
+	}
+
+	if c := parse("a=1\nb=2").Compare(parse("b=2\na=1")); c != 0 {
+		t.Errorf("Failed compare independent of order: %d", c)
+	}
+	if c := parse("a=1").Compare(parse("a=2")); c != -1 {
+		t.Errorf("Failed compare values: %d", c)
+	}
+	if c := parse("b=1").Compare(parse("a=1\nb=1")); c != 1 {
+		t.Errorf("Failed compare keys: %d", c)
+	}
+	if parse("a=1").Equal(parse("a=1\nb=")) {
+		t.Errorf("Failed equal with extra key")
+	}
+}
+
+func TestEnvMapExportString(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "B")
+	numbered := func(i int, k, v string) string {
+		return fmt.Sprintf("%d. %s is %s\n", i+1, k, v)
+	}
+
+	var buf bytes.Buffer
+	m.Export(&buf, numbered)
+	if s := m.ExportString(numbered); s != buf.String() || s != "1. a is A\n2. b is B\n" {
+		t.Errorf("Failed export string: %q", s)
+	}
+	if s := NewEnvMap().ExportString(numbered); s != "" {
+		t.Errorf("Failed export string of empty map: %q", s)
+	}
+}
+
+func TestEnvMapEmitNUL(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "A")
+	m.Set("b", "two\nlines")
+	m.Set("c", "")
+
+	var b strings.Builder
+	m.EmitNUL(&b)
+	if expected := "a=A\x00b=two\nlines\x00c=\x00"; b.String() != expected {
+		t.Errorf("Failed emit NUL: %q", b.String())
+	}
+}
+
+func TestEnvMapMove(t *testing.T) {
+	tests := []struct {
+		key      string
+		to       int
+		expected string
+	}{
+		{"b", 3, "acdb"}, // forward
+		{"c", 0, "cabd"}, // backward
+		{"a", -5, "abcd"},
+		{"a", 10, "bcda"},
+		{"d", 0, "dabc"},
+		{"b", 1, "abcd"},
+	}
+	for _, tt := range tests {
+		m := NewEnvMap()
+		for _, k := range "abcd" {
+			m.Set(string(k), strings.ToUpper(string(k)))
+		}
+		if err := m.Move(tt.key, tt.to); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		order := strings.Join(m.Keys(), "")
+		if order != tt.expected {
+			t.Errorf("Failed move of %s to %d: %s", tt.key, tt.to, order)
+		}
+		for i, p := range m.entries {
+			if _, at := m.Get(p.Key); at != i || p.Val != strings.ToUpper(p.Key) {
+				t.Errorf("Failed move index of %s: %d", p.Key, at)
+			}
+		}
+	}
+
+	if err := NewEnvMap().Move("x", 0); err == nil {
+		t.Errorf("Failed move of missing key")
+	}
+}
+
+func TestEnvMapReset(t *testing.T) {
+	m := NewEnvMap().With("a", "A").With("b", "B")
+	capacity := cap(m.entries)
+	m.Reset()
+	if m.Len() != 0 || cap(m.entries) != capacity {
+		t.Errorf("Failed reset: len %d, cap %d", m.Len(), cap(m.entries))
+	}
+	if _, at := m.Get("a"); at >= 0 {
+		t.Errorf("Failed reset: a still present")
+	}
+
+	m.Set("c", "C")
+	if v, at := m.Get("c"); v != "C" || at != 0 || m.Len() != 1 {
+		t.Errorf("Failed set after reset: %s %d", v, at)
+	}
+}
+
+func TestEnvMapSortByValue(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("a", "xx")
+	m.Set("b", "xxxx")
+	m.Set("c", "x")
+	m.Set("d", "yy")
+
+	m.SortByValue(func(a, b string) bool { return len(a) > len(b) })
+	if order := strings.Join(m.Keys(), ""); order != "badc" {
+		t.Errorf("Failed sort by value: %s", order)
+	}
+	for i, p := range m.entries {
+		if v, at := m.Get(p.Key); at != i || v != p.Val {
+			t.Errorf("Failed sort by value index of %s: %d", p.Key, at)
+		}
+	}
+}
+
+func TestEnvMapResolve(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOME", "/home/gopher")
+
+	m := NewEnvMap()
+	m.Set("URL", "http://${HOST}:$PORT/")
+	m.Set("HOST", "$NAME.local")
+	m.Set("NAME", "app")
+	m.Set("PORT", "8080")
+	m.Set("DIR", "$HOME/app")
+	m.Set("LITERAL", "\\$NAME")
+
+	if err := m.Resolve(); err != nil {
+		t.Fatalf("Failed resolve: %v", err)
+	}
+	expected := []Pair{
+		{Key: "URL", Val: "http://app.local:8080/", Raw: "http://${HOST}:$PORT/"},
+		{Key: "HOST", Val: "app.local", Raw: "$NAME.local"},
+		{Key: "NAME", Val: "app"},
+		{Key: "PORT", Val: "8080"},
+		{Key: "DIR", Val: "/home/gopher/app", Raw: "$HOME/app"},
+		{Key: "LITERAL", Val: "$NAME", Raw: "\\$NAME"},
+	}
+	if !reflect.DeepEqual(m.entries, expected) {
+		t.Errorf("Failed resolve values: %v", m.entries)
+	}
+
+	m = NewEnvMap()
+	m.Set("A", "a$B")
+	m.Set("B", "b$A")
+	m.Set("C", "c$C")
+	m.Set("D", "d$A")
+	m.Set("E", "e")
+	err := m.Resolve()
+	if err == nil || err.Error() != "cyclic references among A, B, C, D" {
+		t.Errorf("Failed resolve cycles: %v", err)
+	}
+	expected = []Pair{
+		{Key: "A", Val: "a$B"},
+		{Key: "B", Val: "b$A"},
+		{Key: "C", Val: "c$C"},
+		{Key: "D", Val: "d$A"},
+		{Key: "E", Val: "e"},
+	}
+	if !reflect.DeepEqual(m.entries, expected) {
+		t.Errorf("Failed resolve cycles values: %v", m.entries)
+	}
+}
+
+func TestEnvMapSize(t *testing.T) {
+	m := NewEnvMap()
+	if m.Size() != 0 {
+		t.Errorf("Failed size of empty map: %d", m.Size())
+	}
+	m.Set("a", "A")
+	m.Set("bb", "\"quoted\"\n")
+	m.Set("c", "")
+
+	var b strings.Builder
+	m.EmitNUL(&b)
+	if m.Size() != 20 || m.Size() != b.Len() {
+		t.Errorf("Failed size: %d", m.Size())
+	}
+}
+
+func TestEnvMapMaxKeyLen(t *testing.T) {
+	m := NewEnvMap()
+	if m.MaxKeyLen() != 0 {
+		t.Errorf("Failed max key len of empty map: %d", m.MaxKeyLen())
+	}
+	m.Set("A", "a long value")
+	m.Set("LONGEST", "")
+	m.Set("MID", "x")
+	if m.MaxKeyLen() != 7 {
+		t.Errorf("Failed max key len: %d", m.MaxKeyLen())
+	}
+}
@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+package godotenv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"KEY=value",
+		"export KEY='single # quoted'",
+		"KEY=\"double\\n\\\"quoted\\\" $OTHER ${OTHER}\" # comment",
+		"A=1\nB=${A}${env:A}${file:A}%A%\\$A",
+		"KEY: yaml",
+		"KEY=\"multi\nline\"",
+		"KEY=\"a\"'b'c",
+		"[section]\nKEY=${",
+		"include other.env",
+		"K+=v",
+		"=\"",
+		"#\"'#",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, opts := range []ParseOptions{
+			{},
+			{Expand: true},
+			{Expand: true, Multiline: true, TrimMultiline: true, ExpandPercent: true, ConcatQuotes: true, Append: true},
+			{Expand: true, StrictEscapes: true, OnUndefined: UndefinedError, KeyCase: KeyUpper, Separator: ":"},
+		} {
+			ParseWithOptions(bytes.NewReader(data), opts)
+			ParseDiagnostics(bytes.NewReader(data), opts)
+		}
+		Format(data)
+		References(bytes.NewReader(data))
+	})
+}
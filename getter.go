@@ -0,0 +1,75 @@
+package godotenv
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Getter reads the values of an EnvMap as other types without asking for an
+// error at every turn, for scripts and prototypes: a missing key, or a value
+// that doesn't parse, gives the zero value of the type. Err tells whether any
+// value failed to parse. Use the error-returning getters of EnvMap, such as
+// GetDuration, where the difference matters.
+type Getter struct {
+	m   *EnvMap
+	err *error
+}
+
+// Getter returns a Getter for the map.
+func (m *EnvMap) Getter() Getter {
+	return Getter{m: m, err: new(error)}
+}
+
+// String returns the value of key, or "" if it is missing.
+func (g Getter) String(key string) string {
+	val, _ := g.m.Get(key)
+	return val
+}
+
+// Int returns the value of key as an int, or 0.
+func (g Getter) Int(key string) int {
+	var n int
+	g.parse(key, func(val string) (err error) {
+		n, err = strconv.Atoi(val)
+		return
+	})
+	return n
+}
+
+// Bool returns the value of key as a bool, as by strconv.ParseBool, or false.
+func (g Getter) Bool(key string) bool {
+	var b bool
+	g.parse(key, func(val string) (err error) {
+		b, err = strconv.ParseBool(val)
+		return
+	})
+	return b
+}
+
+// Duration returns the value of key as a time.Duration, or 0.
+func (g Getter) Duration(key string) time.Duration {
+	var d time.Duration
+	g.parse(key, func(val string) (err error) {
+		d, err = time.ParseDuration(val)
+		return
+	})
+	return d
+}
+
+// Err returns the error of the last value that failed to parse, or nil.
+// Missing keys are no error.
+func (g Getter) Err() error {
+	return *g.err
+}
+
+// parse calls f with the value of key, if present, keeping the error.
+func (g Getter) parse(key string, f func(val string) error) {
+	val, at := g.m.Get(key)
+	if at < 0 {
+		return
+	}
+	if err := f(val); err != nil {
+		*g.err = fmt.Errorf("%s=%q: %w", key, val, err)
+	}
+}
@@ -0,0 +1,47 @@
+package godotenv
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetter(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("NAME", "app")
+	m.Set("PORT", "8080")
+	m.Set("DEBUG", "true")
+	m.Set("TIMEOUT", "1m30s")
+
+	g := m.Getter()
+	if v := g.String("NAME"); v != "app" {
+		t.Errorf("Failed getter string: %q", v)
+	}
+	if v := g.Int("PORT"); v != 8080 {
+		t.Errorf("Failed getter int: %d", v)
+	}
+	if v := g.Bool("DEBUG"); !v {
+		t.Errorf("Failed getter bool: %v", v)
+	}
+	if v := g.Duration("TIMEOUT"); v != 90*time.Second {
+		t.Errorf("Failed getter duration: %v", v)
+	}
+
+	if g.String("MISSING") != "" || g.Int("MISSING") != 0 || g.Bool("MISSING") || g.Duration("MISSING") != 0 {
+		t.Errorf("Expected zero values for a missing key")
+	}
+	if err := g.Err(); err != nil {
+		t.Errorf("Expected no error for missing keys, got %v", err)
+	}
+
+	if v := g.Int("NAME"); v != 0 {
+		t.Errorf("Expected 0 for a malformed int, got %d", v)
+	}
+	if err := g.Err(); err == nil || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Expected the conversion error, got %v", err)
+	}
+	if err := m.Getter().Err(); err != nil {
+		t.Errorf("Expected a fresh getter to have no error, got %v", err)
+	}
+}
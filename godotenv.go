@@ -15,19 +15,64 @@ package godotenv
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
+// knownEscapes are the characters other than n and r that a backslash may
+// escape in double quotes under StrictEscapes: those Marshal escapes, and $.
+const knownEscapes = "\\\"!`$"
+
 // Double quoting dollar will cause var references to be disabled, that's not what we want!
 //const doubleQuoteSpecialChars = "\\\n\r\"!$`"
 const doubleQuoteSpecialChars = "\\\n\r\"!`"
 
+// MaxFileSize, when positive, is the size in bytes beyond which env files are
+// refused with ErrFileTooLarge. It guards against a misconfigured path that
+// points at, say, a multi-gigabyte log.
+var MaxFileSize int64
+
+// compiled once, as they are used for every line parsed
+var (
+	keyRegex          = regexp.MustCompile(`^\s*(?:export\s+)?(.*?)\s*$`)
+	singleQuotesRegex = regexp.MustCompile(`(?s)\A'(.*)'\z`)
+	doubleQuotesRegex = regexp.MustCompile(`(?s)\A"(.*)"\z`)
+	escapeRegex       = regexp.MustCompile(`\\.`)
+	unescapeRegex     = regexp.MustCompile(`\\([^$])`)
+	validKeyRegex     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	includeRegex      = regexp.MustCompile(`^\s*include\s+([^\s=:].*?)\s*$`)
+	requiredRegex     = regexp.MustCompile(`^\s*#\s*@required\s*$`)
+	sectionRegex      = regexp.MustCompile(`^\s*\[\s*([^\]]*?)\s*\]\s*(?:#.*)?$`)
+	exportRegex       = regexp.MustCompile(`^\s*export\s`)
+)
+
+// MaxLineLength is the length in bytes of the longest line env content may
+// have. It is generous, to make room for things like certificates or JSON keys
+// on a single line, but can be raised for even longer ones.
+var MaxLineLength = 1 << 20
+
+// DisableColonSeparator makes parsing split lines only at =, as if every
+// ParseOptions had a Separator of "=" unless it pins one itself. Set it if you
+// don't use YAML-style lines, so that colons in keys and values, as in URLs or
+// times, are never taken for separators.
+var DisableColonSeparator bool
+
+// MaxIncludeDepth is how deeply includes may nest, with the Includes option.
+var MaxIncludeDepth = 10
+
+// ErrFileTooLarge is returned for env files larger than MaxFileSize.
+var ErrFileTooLarge = errors.New("env file too large")
+
 // Load will read your env file(s) and load them into ENV for this process.
 //
 // Call this function as close as possible to the start of your program (ideally in main)
@@ -38,9 +83,22 @@ const doubleQuoteSpecialChars = "\\\n\r\"!`"
 //
 //		godotenv.Load("fileone", "filetwo")
 //
+// A leading ~ in a filename stands for your home directory, as in ~/.config/app/.env
+//
+// A directory stands for the .env and .env.* files in it, in sorted order, so
+// that .env comes first. It is an error if there are none, unless SkipEmptyDirs
+// is set. This goes for all functions taking env filenames.
+//
 // It's important to note that it WILL NOT OVERRIDE an env variable that already exists - consider the .env file to set dev vars or sensible defaults
+//
+// Files are loaded one after the other, so this holds between them too: a key
+// in more than one file gets its value from the first, the opposite of Read.
+// Use LoadMerged to have later files take precedence.
 func Load(filenames ...string) (err error) {
-	filenames = filenamesOrDefault(filenames)
+	filenames, err = envFilenames(filenames)
+	if err != nil {
+		return
+	}
 
 	for _, filename := range filenames {
 		err = loadFile(filename, false, true)
@@ -51,6 +109,103 @@ func Load(filenames ...string) (err error) {
 	return
 }
 
+// LoadMerged is like Load, except that among the files the last one wins: the
+// files are merged as by ReadMerged, and only then loaded into ENV. Variables
+// that already exist are still not overridden.
+//
+//		godotenv.LoadMerged(".env", ".env.local") // .env.local trumps .env
+func LoadMerged(filenames ...string) error {
+	envMap, err := ReadMerged(filenames...)
+	if err != nil {
+		return err
+	}
+	applyEnv(envMap, false)
+	return nil
+}
+
+// LoadWithOverrides is Load for all but the keys in overrideKeys, which are
+// loaded as by Overload: they are set even if they already exist, and among
+// the files the last one wins.
+//
+//		godotenv.LoadWithOverrides([]string{"LOG_LEVEL"}, ".env")
+func LoadWithOverrides(overrideKeys []string, filenames ...string) error {
+	filenames, err := envFilenames(filenames)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]bool, len(overrideKeys))
+	for _, k := range overrideKeys {
+		overrides[k] = true
+	}
+
+	for _, filename := range filenames {
+		envMap, err := readFile(filename, true)
+		if err != nil {
+			return err
+		}
+		applyEnvKeys(envMap, func(k string) bool { return overrides[k] })
+	}
+	return nil
+}
+
+// LoadIf is Load, if cond holds, and does nothing otherwise.
+//
+//		godotenv.LoadIf(func() bool { return os.Getenv("APP_ENV") == "dev" }, ".env.dev")
+func LoadIf(cond func() bool, filenames ...string) error {
+	if !cond() {
+		return nil
+	}
+	return Load(filenames...)
+}
+
+// LoadForEnv loads, as by Load, the files mapping lists for the value of the
+// environment variable envVar, so that each environment gets its own:
+//
+//		godotenv.LoadForEnv("APP_ENV", map[string][]string{
+//			"":     {".env"}, // APP_ENV unset or empty
+//			"dev":  {".env.dev", ".env"},
+//			"prod": {}, // nothing to load
+//		})
+//
+// It is an error if mapping has no entry for the value. An empty list of files
+// loads nothing, rather than .env as Load would.
+func LoadForEnv(envVar string, mapping map[string][]string) error {
+	value := os.Getenv(envVar)
+	filenames, ok := mapping[value]
+	if !ok {
+		return fmt.Errorf("no files to load for %s=%q", envVar, value)
+	}
+	if len(filenames) == 0 {
+		return nil
+	}
+	return Load(filenames...)
+}
+
+// LoadFirst loads, as by Load, only the first of filenames that exists, so
+// that a local file can stand in for the shared one:
+//
+//		godotenv.LoadFirst(".env.local", ".env")
+//
+// Files that don't exist are skipped, but one that exists and fails to parse
+// is an error. If none of them exists, the error matches os.ErrNotExist, for
+// callers to whom that is no error at all.
+func LoadFirst(filenames ...string) error {
+	if len(filenames) == 0 {
+		filenames = []string{".env"}
+	}
+	for _, filename := range filenames {
+		name, err := expandHome(filename)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			continue
+		}
+		return Load(filename)
+	}
+	return fmt.Errorf("none of %s found: %w", strings.Join(filenames, ", "), os.ErrNotExist)
+}
+
 // Overload will read your env file(s) and load them into ENV for this process.
 //
 // Call this function as close as possible to the start of your program (ideally in main)
@@ -63,7 +218,10 @@ func Load(filenames ...string) (err error) {
 //
 // It's important to note this WILL OVERRIDE an env variable that already exists - consider the .env file to forcefilly set all vars.
 func Overload(filenames ...string) (err error) {
-	filenames = filenamesOrDefault(filenames)
+	filenames, err = envFilenames(filenames)
+	if err != nil {
+		return
+	}
 
 	for _, filename := range filenames {
 		err = loadFile(filename, true, true)
@@ -78,14 +236,115 @@ func ReadNoExpand(filenames ...string) (envMap *EnvMap, err error) {
 	return read(false, filenames...)
 }
 
+// LoadProfile is like Load, but for env files split into INI-style sections
+//
+//		SHARED=1
+//		[dev]
+//		DB_HOST=localhost
+//		[prod]
+//		DB_HOST=db.internal
+//
+// Only the keys before the first section header and those in the section named
+// by profile are loaded; keys of other sections are ignored. It is an error if
+// none of the files has a section for profile.
+func LoadProfile(profile string, filenames ...string) error {
+	filenames, err := envFilenames(filenames)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	var envMaps []*EnvMap
+	for _, filename := range filenames {
+		file, err := openFile(filename)
+		if err != nil {
+			return err
+		}
+		r, err := fileReader(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		envMap, foundInFile, err := parseProfile(r, profile, true)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		found = found || foundInFile
+		envMaps = append(envMaps, envMap)
+	}
+	if !found {
+		return fmt.Errorf("profile [%s] not found", profile)
+	}
+
+	for _, envMap := range envMaps {
+		applyEnv(envMap, false)
+	}
+	return nil
+}
+
 // Read all env (with same file loading semantics as Load) but return values as
 // a map rather than automatically writing values into env
 func Read(filenames ...string) (envMap *EnvMap, err error) {
 	return read(true, filenames...)
 }
 
+// ReadWithOptions is like Read, but parses the files with the behaviour given
+// by opts, and leaves ENV alone. As with Read, references in a file resolve
+// against the keys of the files before it, then the environment.
+func ReadWithOptions(opts ParseOptions, filenames ...string) (*EnvMap, error) {
+	return readFilesWith(filenames, opts)
+}
+
+// ReadMerged is like Read, but lets references in one file resolve against
+// keys from any of the others, also those that come later. This makes it
+// possible to split, say, .env.base from .env.secrets with references going
+// both ways. The environment of this process is not changed.
+//
+// The files are merged as written, the last one winning among them, and only
+// then are references expanded, once, against the merged keys as by
+// EnvMap.Resolve, so that they follow chains across files. Names that aren't
+// keys are looked up in the environment, as are references of keys to
+// themselves, as in PATH=${PATH}:/opt/bin. References in a cycle are an error.
+func ReadMerged(filenames ...string) (*EnvMap, error) {
+	filenames, err := envFilenames(filenames)
+	if err != nil {
+		return nil, err
+	}
+
+	envMap := NewEnvMap()
+	for _, filename := range filenames {
+		content, err := readContent(filename)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := ParseWithOptions(bytes.NewReader(content), ParseOptions{PreserveQuotes: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range raw.entries {
+			envMap.setPair(pair)
+		}
+	}
+	if err := envMap.resolve(true); err != nil {
+		return nil, err
+	}
+	for i := range envMap.entries {
+		envMap.entries[i].Quote = QuoteUnknown // only kept single-quoted values literal
+	}
+	return envMap, nil
+}
+
 func read(expand bool, filenames ...string) (envMap *EnvMap, err error) {
-	filenames = filenamesOrDefault(filenames)
+	return readVia(readFile, expand, filenames)
+}
+
+// readVia is read with the files read by readFile.
+func readVia(readFile func(filename string, expand bool) (*EnvMap, error), expand bool, filenames []string) (envMap *EnvMap, err error) {
+	filenames, err = envFilenames(filenames)
+	if err != nil {
+		return
+	}
 	envMap = NewEnvMap()
 
 	for _, filename := range filenames {
@@ -95,93 +354,874 @@ func read(expand bool, filenames ...string) (envMap *EnvMap, err error) {
 			err = individualErr
 			return // return early on a spazout
 		}
-		individualEnvMap.Iter(func(k, v string) {
-			envMap.Set(k, v)
-			os.Setenv(k, v) // readFile() and descendats will only respect ENV to fill in vars!
-		})
+		for _, pair := range individualEnvMap.entries {
+			envMap.setPair(pair)
+			os.Setenv(pair.Key, pair.Val) // readFile() and descendats will only respect ENV to fill in vars!
+		}
 	}
 
 	return
 }
 
 // Parse reads an env file from io.Reader, returning a map of keys and values.
+//
+// Any content is safe to parse, however malformed: Parse, like the other
+// parsing functions, does not panic on it, as FuzzParse checks. Malformed
+// lines are an error.
 func Parse(r io.Reader, expand bool) (envMap *EnvMap, err error) {
-	envMap = NewEnvMap()
+	return ParseWithOptions(r, ParseOptions{Expand: expand})
+}
+
+// ParseOptions tweaks how env content is parsed. The zero value parses like
+// Parse without expansion.
+type ParseOptions struct {
+	// Expand enables $VAR and ${VAR} references in values.
+	Expand bool
+
+	// LowercaseNames lets references name variables with lower case letters,
+	// as in $home or ${home}. Names are upper case otherwise, and a $ before
+	// a lower case letter, as in pa$word, is kept as written.
+	LowercaseNames bool
+
+	// Multiline lets a quoted value run over several lines, up to the line
+	// with its closing quote. The value keeps the line breaks, and everything
+	// else between the quotes, exactly unless TrimMultiline is also set. A
+	// value still open at the end of the input is an error.
+	Multiline bool
+
+	// TrimMultiline trims leading and trailing whitespace, line breaks
+	// included, off values that span several lines.
+	TrimMultiline bool
+
+	// DetectCaseCollisions makes ParseStrict and ParseDiagnostics report
+	// keys that differ only by case, like Path and PATH, which clash in the
+	// case-insensitive environment of Windows.
+	DetectCaseCollisions bool
+
+	// KeyCase folds every key to upper or lower case. References to keys
+	// read before are folded the same way, so that they still resolve. Keys
+	// that differ only by case end up the same; ParseStrict and
+	// ParseDiagnostics report them as case collisions.
+	KeyCase KeyCase
+
+	// ExpandPercent enables Windows-style %VAR% references in values, for
+	// files ported from batch scripts. Percent signs that aren't part of
+	// such a reference are kept.
+	ExpandPercent bool
+
+	// ExpandEscape is the character that keeps a following $ literal rather
+	// than the start of a reference. It defaults to a backslash; pick another,
+	// such as ^, for files full of backslashes like Windows paths.
+	ExpandEscape rune
+
+	// OnUndefined says what becomes of references to variables that are
+	// defined neither in the content nor in the environment.
+	OnUndefined Undefined
+
+	// StrictEscapes fails on escape sequences in double-quoted values other
+	// than \n, \r, \\, \", \$, \! and \`, rather than dropping the backslash,
+	// to catch typos like \N.
+	StrictEscapes bool
+
+	// ConcatQuotes joins adjacent quoted and unquoted parts of a value, as the
+	// shell does, so that "a"'b'c reads as abc. Each part is unquoted on its
+	// own: references expand within double quotes and outside of quotes,
+	// but not within single quotes.
+	ConcatQuotes bool
+
+	// Separator pins what separates keys from values: "=" takes colons for
+	// part of the value, as in KEY=a:b, and ":" reads YAML-style lines only.
+	// Left empty, each line is split at whichever of the two comes first,
+	// unless DisableColonSeparator is set.
+	Separator string
+
+	// ExpandKeys expands references in keys, as in ${PREFIX}_HOST=x, whether
+	// or not Expand is set for values. A reference to a variable that is not
+	// defined fails the line, as does a key that expands to one that isn't a
+	// valid name.
+	ExpandKeys bool
+
+	// EscapesInUnquoted resolves escape sequences like \n in unquoted values
+	// as it is done in double quotes, for files written for tools that don't
+	// tie escapes to quotes. Unquoted values are taken literally otherwise.
+	EscapesInUnquoted bool
+
+	// SeparatorRegex, if set, splits each line at its first match, for
+	// dialects like KEY -> value. It takes precedence over Separator.
+	SeparatorRegex *regexp.Regexp
+
+	// Includes makes lines like
+	//
+	//	include common.env
+	//
+	// stand for the lines of the named file, which may in turn include
+	// others. A relative path is taken relative to the directory of the file
+	// the line is in, as read by ReadWithOptions, or to the working directory
+	// for content that isn't read from a file. The included keys go where the
+	// include line is, so that later lines override them and may refer to
+	// them, just as if the file were pasted in. A file that can't be read, or
+	// that includes itself in the end, fails the include line. Problems
+	// found in an included file name it in Diagnostic.File, and keys it sets
+	// that are set again outside it are overrides, not duplicate keys.
+	Includes bool
+
+	// RequiredDirectives marks the key right after a # @required comment
+	// line as required, in Pair.Required, for Validate to check:
+	//
+	//	# @required
+	//	API_KEY=
+	RequiredDirectives bool
+
+	// Append makes KEY+=value append value to the value KEY has so far, in
+	// the content read before or else in the environment, as in
+	// PATH+=:/opt/bin. A key without a value yet is set to value.
+	Append bool
+
+	// PreserveQuotes records in Pair.Quote whether each value was single
+	// quoted, double quoted or not quoted at all, for Marshal to write it
+	// the same way. Values joined from parts by ConcatQuotes aren't recorded.
+	PreserveQuotes bool
+
+	// ResolveSchemes replaces values of the form scheme://ref, as in
+	// secret://vault/db/password, by what the resolver registered for the
+	// scheme with RegisterResolver returns for ref. A resolver that fails
+	// fails the line. Values with other schemes, like URLs, are kept as they
+	// are, unless StrictSchemes is also set, and so are single-quoted values.
+	// Pair.Raw keeps the reference, so MarshalRaw never writes a secret out.
+	ResolveSchemes bool
+
+	// StrictSchemes fails values of the form scheme://ref whose scheme has no
+	// resolver, when ResolveSchemes is set. Mind that URLs look the same.
+	StrictSchemes bool
+}
+
+// KeyCase is the case keys are folded to.
+type KeyCase int
+
+const (
+	KeyAsIs KeyCase = iota
+	KeyUpper
+	KeyLower
+)
+
+// Undefined is what expansion does with references to undefined variables.
+type Undefined int
+
+const (
+	// UndefinedEmpty replaces the reference with an empty string, as the
+	// shell does.
+	UndefinedEmpty Undefined = iota
+	// UndefinedKeep leaves the reference as it is, for a later stage to
+	// resolve.
+	UndefinedKeep
+	// UndefinedError fails the parse.
+	UndefinedError
+)
+
+// ParseWithOptions is like Parse, with the behaviour given by opts.
+func ParseWithOptions(r io.Reader, opts ParseOptions) (envMap *EnvMap, err error) {
+	p := newParser(opts)
+	err = p.parse(r)
+	return p.envMap, err
+}
+
+// ParseWithDefaults is like Parse, but starts from the given defaults, which
+// come first in the map in order of key. Values in r override them and may
+// refer to them.
+func ParseWithDefaults(r io.Reader, defaults map[string]string, expand bool) (envMap *EnvMap, err error) {
+	p := newParser(ParseOptions{Expand: expand})
+	p.envMap = FromMap(defaults)
+	err = p.parse(r)
+	return p.envMap, err
+}
+
+// References returns the names of the variables that values in r refer to but
+// that r does not define before, and so come from the environment, in order of
+// name. These are the inputs a file needs from the environment it is loaded
+// into. ${env:NAME} references count even if r defines NAME; escaped ones and
+// those in single quotes don't count.
+func References(r io.Reader) ([]string, error) {
+	p := newParser(ParseOptions{Expand: true})
+	p.external = map[string]bool{}
+	if err := p.parse(r); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(p.external))
+	for name := range p.external {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// parser holds the state of a single parse: the values read so far, which
+// references expand against, and the diagnostics found on the way.
+type parser struct {
+	opts   ParseOptions
+	envMap *EnvMap
+	line   int
+
+	// collect makes the parser record diagnostics, and carry on past
+	// malformed lines rather than stop at the first one.
+	collect bool
+	diags   []Diagnostic
+
+	// fallback, if set, resolves references before the environment does.
+	fallback *EnvMap
+
+	// external, if set, records the names looked up in the environment.
+	external map[string]bool
+
+	// dir is the directory of the file being parsed, if any, which includes
+	// are relative to, and including the files being parsed, outermost first.
+	// depth counts the includes among them.
+	dir       string
+	including []string
+	depth     int
+
+	// file numbers the file being parsed among those included, in the order
+	// they were, and files counts them. origin maps keys to the file they
+	// were last set in, to tell overrides of included keys from duplicates.
+	file, files int
+	origin      map[string]int
+
+	// raw is the value of the line parsed last as written, if expanding
+	// references changed it.
+	raw string
+
+	// quote is how the value of the line parsed last was quoted. It is only
+	// kept in the map if the options say to preserve it.
+	quote Quote
 
+	// required tells whether a # @required directive precedes the line parsed
+	// last, if the options say to look for them.
+	required bool
+
+	// valueErr is an error found in the value of the line parsed last, which
+	// fails the line once it is parsed.
+	valueErr error
+
+	expandRegex *regexp.Regexp // built on first use to suit opts
+
+	// folded maps upper-cased keys to where they first appeared, to detect
+	// keys that differ only by case.
+	folded map[string]keyPos
+}
+
+type keyPos struct {
+	key  string
+	line int
+}
+
+func newParser(opts ParseOptions) *parser {
+	return &parser{opts: opts, envMap: NewEnvMap()}
+}
+
+func (p *parser) parse(r io.Reader) error {
+	lines, err := readLines(r)
+	if err != nil {
+		return err
+	}
+	p.envMap.grow(len(lines)) // at most one key per line
+
+	marked := false // by a directive on the line before
+	for i := 0; i < len(lines); i++ {
+		fullLine := lines[i]
+		p.line = i + 1
+		p.required = marked
+		marked = p.opts.RequiredDirectives && requiredRegex.MatchString(fullLine)
+		if m := p.includeLine(fullLine); m != nil {
+			if err := p.include(m[1]); err != nil {
+				d, ok := err.(Diagnostic)
+				if !p.collect || !ok {
+					return err
+				}
+				p.diags = append(p.diags, d)
+			}
+			continue
+		}
+		if !isIgnoredLine(fullLine) {
+			joined := false
+			if q := p.unclosedQuote(fullLine); p.opts.Multiline && q != 0 {
+				start, closed := i, false
+				for !closed && i+1 < len(lines) {
+					i++
+					closed = closingQuote(lines[i], q) >= 0
+				}
+				fullLine = strings.Join(lines[start:i+1], "\n")
+				joined = i > start
+				if !closed {
+					// the file ends mid-value
+					p.line = len(lines)
+					err := p.fail(KindMalformedLine, "unterminated quoted value starting on line %d", start+1)
+					if !p.collect {
+						return err
+					}
+					p.diags = append(p.diags, err.(Diagnostic))
+					continue
+				}
+			}
+
+			key, value, err := p.parseLine(fullLine)
+
+			if err != nil {
+				d, ok := err.(Diagnostic)
+				if !p.collect || !ok {
+					return err
+				}
+				p.diags = append(p.diags, d)
+				continue
+			}
+			if joined && p.opts.TrimMultiline {
+				value = strings.TrimSpace(value)
+				p.raw = strings.TrimSpace(p.raw)
+			}
+			p.set(key, value)
+		}
+	}
+	return nil
+}
+
+// includeLine matches line against includeRegex, if the options allow
+// includes.
+func (p *parser) includeLine(line string) []string {
+	if !p.opts.Includes {
+		return nil
+	}
+	return includeRegex.FindStringSubmatch(line)
+}
+
+// include parses the file at path where the current line is, as if its lines
+// were in place of it.
+func (p *parser) include(path string) error {
+	if i := strings.Index(path, " #"); i >= 0 {
+		path = strings.TrimSpace(path[:i])
+	}
+	path, err := expandHome(strings.Trim(path, `"'`))
+	if err != nil {
+		return p.fail(KindInclude, "can't include %s: %v", path, err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.dir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, including := range p.including {
+		if including == path {
+			chain := append(append([]string{}, p.including...), path)
+			return p.fail(KindInclude, "include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if p.depth >= MaxIncludeDepth {
+		return p.fail(KindInclude, "includes nested more than %d deep, see MaxIncludeDepth", MaxIncludeDepth)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return p.fail(KindInclude, "can't include %s: %v", path, err)
+	}
+	defer file.Close()
+	r, err := fileReader(file)
+	if err != nil {
+		return p.fail(KindInclude, "can't include %s: %v", path, err)
+	}
+
+	line, dir, including, n := p.line, p.dir, p.including, p.file
+	p.dir, p.including = filepath.Dir(path), append(including, path)
+	p.depth++
+	p.files++
+	p.file = p.files
+	err = p.parse(r)
+	p.line, p.dir, p.including, p.file = line, dir, including, n
+	p.depth--
+	return err
+}
+
+// readLines splits r into lines, which may be up to MaxLineLength long.
+func readLines(r io.Reader) ([]string, error) {
 	var lines []string
 	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineLength)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
 
-	if err = scanner.Err(); err != nil {
+	err := scanner.Err()
+	if err == bufio.ErrTooLong {
+		err = fmt.Errorf("line %d: longer than %d bytes, see MaxLineLength: %w", len(lines)+1, MaxLineLength, err)
+	}
+	return lines, err
+}
+
+func (p *parser) set(key, value string) {
+	if !validKey(key) {
+		p.report(KindInvalidKey, "invalid key %q", key)
+	}
+	collision := false
+	if p.opts.DetectCaseCollisions || p.opts.KeyCase != KeyAsIs {
+		if p.folded == nil {
+			p.folded = make(map[string]keyPos)
+		}
+		folded := strings.ToUpper(key)
+		if prev, ok := p.folded[folded]; !ok {
+			p.folded[folded] = keyPos{key, p.line}
+		} else if prev.key != key {
+			p.report(KindCaseCollision, "key %s differs only by case from %s on line %d", key, prev.key, prev.line)
+			collision = true
+		}
+	}
+	key = p.foldKey(key)
+	if _, at := p.envMap.Get(key); at >= 0 && !collision && p.origin[key] == p.file {
+		p.report(KindDuplicateKey, "duplicate key %s", key)
+	}
+	if p.opts.Includes {
+		if p.origin == nil {
+			p.origin = make(map[string]int)
+		}
+		p.origin[key] = p.file
+	}
+	quote := QuoteUnknown
+	if p.opts.PreserveQuotes {
+		quote = p.quote
+	}
+	p.envMap.setPair(Pair{Key: key, Val: value, Raw: p.raw, Quote: quote, Required: p.required})
+}
+
+// foldKey changes the case of key as the options say.
+func (p *parser) foldKey(key string) string {
+	switch p.opts.KeyCase {
+	case KeyUpper:
+		return strings.ToUpper(key)
+	case KeyLower:
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+func (p *parser) diagnostic(kind, format string, args ...interface{}) Diagnostic {
+	d := Diagnostic{Line: p.line, Kind: kind, Message: fmt.Sprintf(format, args...)}
+	if p.depth > 0 {
+		d.File = p.including[len(p.including)-1]
+	}
+	return d
+}
+
+// report records a diagnostic for the current line, if we collect them.
+func (p *parser) report(kind, format string, args ...interface{}) {
+	if p.collect {
+		p.diags = append(p.diags, p.diagnostic(kind, format, args...))
+	}
+}
+
+// fail returns an error that stops parsing the current line.
+func (p *parser) fail(kind, format string, args ...interface{}) error {
+	return p.diagnostic(kind, format, args...)
+}
+
+// failValue fails the current line once its value is parsed, or only
+// records a diagnostic if we collect them.
+func (p *parser) failValue(kind, format string, args ...interface{}) {
+	if p.collect {
+		p.report(kind, format, args...)
+	} else if p.valueErr == nil {
+		p.valueErr = p.fail(kind, format, args...)
+	}
+}
+
+// lookup resolves a referenced variable against the values read so far, then
+// the fallback, then the environment.
+func (p *parser) lookup(name string) (string, bool) {
+	if val, ok := p.lookupFile(name); ok {
+		return val, true
+	}
+	return p.lookupEnv(name)
+}
+
+// lookupEnv looks name up in the environment.
+func (p *parser) lookupEnv(name string) (string, bool) {
+	if p.external != nil {
+		p.external[name] = true
+	}
+	return os.LookupEnv(name)
+}
+
+// lookupFile is lookup without the environment, for ${file:NAME}.
+func (p *parser) lookupFile(name string) (string, bool) {
+	name = p.foldKey(name)
+	if val, at := p.envMap.Get(name); at >= 0 {
+		return val, true
+	}
+	if p.fallback != nil {
+		if val, at := p.fallback.Get(name); at >= 0 {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// parseProfile parses the keys outside of any section and those in the section
+// named profile, reporting whether that section was present at all.
+func parseProfile(r io.Reader, profile string, expand bool) (envMap *EnvMap, found bool, err error) {
+	p := newParser(ParseOptions{Expand: expand})
+	envMap = p.envMap
+
+	lines, err := readLines(r)
+	if err != nil {
 		return
 	}
 
-	for _, fullLine := range lines {
-		if !isIgnoredLine(fullLine) {
-			var key, value string
-			key, value, err = parseLine(fullLine, envMap, expand)
+	section := ""
+	for i, line := range lines {
+		p.line = i + 1
+		if isIgnoredLine(line) {
+			continue
+		}
+		if name, ok := sectionName(line); ok {
+			section = name
+			found = found || section == profile
+			continue
+		}
+		if section != "" && section != profile {
+			continue
+		}
 
-			if err != nil {
-				return
+		var key, value string
+		key, value, err = p.parseLine(line)
+		if err != nil {
+			return
+		}
+		p.set(key, value)
+	}
+	return
+}
+
+//Unmarshal reads an env file from a string, returning a map of keys and values.
+func Unmarshal(str string) (envMap *EnvMap, err error) {
+	return Parse(strings.NewReader(str), true)
+}
+
+// ParseLine splits a single line, such as a KEY=VALUE argument, into its key
+// and unquoted value by the same rules as Parse: quotes, escapes, comments and
+// export prefixes are handled, but references are not expanded. Lines that
+// hold no assignment, such as blank lines and comments, are an error, and so
+// are keys that aren't valid variable names.
+func ParseLine(line string) (key, value string, err error) {
+	p := newParser(ParseOptions{})
+	p.line = 1
+	key, value, err = p.parseLine(line)
+	if err == nil && !validKey(key) {
+		err = p.fail(KindInvalidKey, "invalid key %q", key)
+	}
+	return
+}
+
+// Exec loads env vars from the specified filenames (empty map falls back to default)
+// then executes the cmd specified.
+//
+// Simply hooks up os.Stdin/err/out to the command and calls Run()
+//
+// If you want more fine grained control over your command it's recommended
+// that you use `Load()` or `Read()` and the `os/exec` package yourself.
+func Exec(filenames []string, cmd string, cmdArgs []string) error {
+	Load(filenames...)
+
+	return runAttached(exec.Command(cmd, cmdArgs...))
+}
+
+// Command returns a command ready to run name with args, its environment
+// being ours plus the keys of the env files. As with Load, keys already set
+// in our environment are not overridden. The env files are not loaded into
+// this process, and setting up stdio and running the command are left to
+// the caller.
+func Command(filenames []string, name string, args ...string) (*exec.Cmd, error) {
+	envMap, err := readFiles(filenames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	env := os.Environ()
+	envMap.Iter(func(k, v string) {
+		if _, ok := os.LookupEnv(k); !ok {
+			env = append(env, k+"="+v)
+		}
+	})
+
+	command := exec.Command(name, args...)
+	command.Env = env
+	return command, nil
+}
+
+// ExecScoped is like Exec, but cmd gets only those keys of the env files that
+// are listed in allow, and nothing at all from the environment of this
+// process. An empty allow list runs cmd with an empty environment.
+//
+// Unlike Exec, the env files are not loaded into this process either.
+func ExecScoped(filenames []string, allow []string, cmd string, cmdArgs []string) error {
+	envMap, err := readFiles(filenames, true)
+	if err != nil {
+		return err
+	}
+
+	command := exec.Command(cmd, cmdArgs...)
+	command.Env = envMap.Pick(allow...).ToSlice()
+	return runAttached(command)
+}
+
+// ExecClean is like Exec, but cmd gets only those variables of the
+// environment of this process whose names start with one of keepPrefixes, as
+// LC_ or PATH might, along with the keys of the env files. As with Load, the
+// variables kept are not overridden by the env files, which are not loaded
+// into this process.
+func ExecClean(filenames []string, keepPrefixes []string, cmd string, cmdArgs []string) error {
+	envMap, err := readFiles(filenames, true)
+	if err != nil {
+		return err
+	}
+
+	command := exec.Command(cmd, cmdArgs...)
+	command.Env = cleanEnv(envMap, keepPrefixes)
+	return runAttached(command)
+}
+
+// cleanEnv returns the variables of our environment with one of
+// keepPrefixes, followed by the keys of envMap that they don't set.
+func cleanEnv(envMap *EnvMap, keepPrefixes []string) []string {
+	env := []string{}
+	kept := map[string]bool{}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		for _, prefix := range keepPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				env = append(env, kv)
+				kept[name] = true
+				break
 			}
-			envMap.Set(key, value)
 		}
 	}
-	return
-}
-
-//Unmarshal reads an env file from a string, returning a map of keys and values.
-func Unmarshal(str string) (envMap *EnvMap, err error) {
-	return Parse(strings.NewReader(str), true)
+	envMap.Iter(func(k, v string) {
+		if !kept[k] {
+			env = append(env, k+"="+v)
+		}
+	})
+	return env
 }
 
-// Exec loads env vars from the specified filenames (empty map falls back to default)
-// then executes the cmd specified.
-//
-// Simply hooks up os.Stdin/err/out to the command and calls Run()
-//
-// If you want more fine grained control over your command it's recommended
-// that you use `Load()` or `Read()` and the `os/exec` package yourself.
-func Exec(filenames []string, cmd string, cmdArgs []string) error {
-	Load(filenames...)
-
-	command := exec.Command(cmd, cmdArgs...)
+// runAttached runs command hooked up to our stdin, stdout and stderr.
+func runAttached(command *exec.Cmd) error {
 	command.Stdin = os.Stdin
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
 	return command.Run()
 }
 
-// Write serializes the given environment and writes it to a file
+// Write serializes the given environment and writes it to a file, readable
+// only by its owner. The file is replaced atomically, as by Update.
 func Write(envMap *EnvMap, filename string) error {
+	filename, err := expandHome(filename)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := WriteEnv(&buf, envMap); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, buf.Bytes(), 0600)
+}
+
+// Append serializes the given environment as Marshal does and adds it to the
+// end of a file, leaving what the file holds as it is. A line break is added
+// first if the file doesn't end with one, so that lines don't run together.
+// A missing file is created, readable only by its owner.
+//
+// Unlike Write, the file is not replaced atomically; concurrent readers may
+// see part of what is appended.
+func Append(envMap *EnvMap, filename string) error {
+	if envMap.Len() == 0 {
+		return nil
+	}
+	filename, err := expandHome(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
 	content := Marshal(envMap)
-	file, error := os.Create(filename)
-	if error != nil {
-		return error
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			file.Close()
+			return err
+		}
+		if last[0] != '\n' {
+			content = "\n" + content
+		}
+	}
+	if _, err := io.WriteString(file, content); err != nil {
+		file.Close()
+		return err
 	}
-	_, err := file.WriteString(content)
+	return file.Close()
+}
+
+// WriteEnv serializes the given environment as Marshal does and writes it to w.
+func WriteEnv(w io.Writer, envMap *EnvMap) error {
+	_, err := io.WriteString(w, Marshal(envMap))
 	return err
 }
 
 // Marshal outputs the given environment as a dotenv-formatted environment file.
 // Each line is in the format: KEY="VALUE" where VALUE is backslash-escaped.
+//
+// Values whose Pair.Quote was recorded, as with the PreserveQuotes option, are
+// quoted the same way again, unless that can't hold them, as single quotes
+// can't hold a single quote.
 func Marshal(envMap *EnvMap) string {
-	lines := make([]string, 0, envMap.Len())
-	envMap.Iter(func(k, v string) {
-		lines = append(lines, fmt.Sprintf(`%s="%s"`, k, doubleQuoteEscape(v)))
+	return marshal(envMap.Len(), func(f func(k, v string, q Quote)) {
+		for _, p := range envMap.entries {
+			f(p.Key, p.Val, p.Quote)
+		}
+	})
+}
+
+// MarshalRaw is like Marshal, but writes values as they were read, with their
+// references unexpanded, so that they expand again when parsed. See Pair.Raw.
+// Values without references are written with any $ escaped, so that they read
+// back as they are.
+func MarshalRaw(envMap *EnvMap) string {
+	return marshal(envMap.Len(), func(f func(k, v string, q Quote)) {
+		for _, p := range envMap.entries {
+			switch {
+			case p.Raw != "":
+				f(p.Key, p.Raw, p.Quote)
+			case quoteFor(p.Val, p.Quote) == QuoteSingle:
+				f(p.Key, p.Val, QuoteSingle) // nothing expands in single quotes
+			default:
+				f(p.Key, strings.Replace(p.Val, "$", `\$`, -1), p.Quote)
+			}
+		}
+	})
+}
+
+func marshal(n int, iter func(f func(k, v string, q Quote))) string {
+	lines := make([]string, 0, n)
+	iter(func(k, v string, q Quote) {
+		lines = append(lines, formatQuoted(k, v, q))
 	})
 	// We are being used to create referencing lines! No more sorting..
 	//sort.Strings(lines)
 	return strings.Join(lines, "\n") + "\n"
 }
 
-func filenamesOrDefault(filenames []string) []string {
+// Update rewrites the assignments of the keys in changes within an existing
+// env file, leaving comments, blank lines, ordering and all other lines as they
+// were. Keys not yet present in the file are appended in the order of changes.
+// A missing file is treated as empty and created with mode 0600. The file keeps
+// its byte order mark, and with it its encoding, and its style of line breaks.
+//
+// The file is replaced atomically, so concurrent readers see either the old or
+// the new content.
+func Update(filename string, changes *EnvMap) error {
+	doc := &document{}
+	perm := os.FileMode(0600)
+
+	filename, err := expandHome(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err == nil {
+		defer file.Close()
+		if info, err := file.Stat(); err == nil {
+			perm = info.Mode().Perm()
+		}
+		if doc, err = parseDocument(file); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	changes.Iter(func(k, v string) {
+		doc.set(k, v)
+	})
+	return writeFileAtomic(filename, doc.bytes(), perm)
+}
+
+func formatLine(key, val string) string {
+	return fmt.Sprintf(`%s="%s"`, key, doubleQuoteEscape(val))
+}
+
+// formatQuoted is formatLine, quoting val as q says if it can be.
+func formatQuoted(key, val string, q Quote) string {
+	switch quoteFor(val, q) {
+	case QuoteSingle:
+		return key + "='" + val + "'"
+	case QuoteNone:
+		return key + "=" + val
+	}
+	return formatLine(key, val)
+}
+
+// quoteFor returns q, if val reads back as it is when quoted so, and
+// QuoteDouble otherwise.
+func quoteFor(val string, q Quote) Quote {
+	switch q {
+	case QuoteSingle:
+		if !strings.ContainsAny(val, "'\n\r") {
+			return QuoteSingle
+		}
+	case QuoteNone:
+		if !strings.ContainsAny(val, "\"'#\\\n\r") && strings.TrimSpace(val) == val {
+			return QuoteNone
+		}
+	}
+	return QuoteDouble
+}
+
+// SkipEmptyDirs makes directories without env files count as empty rather
+// than as an error, where a directory is given in place of an env file.
+var SkipEmptyDirs bool
+
+// envFilenames returns the env files to read for the given names: .env if
+// there are none, and the .env and .env.* files within, in sorted order, for
+// those that name a directory.
+func envFilenames(filenames []string) ([]string, error) {
 	if len(filenames) == 0 {
-		return []string{".env"}
+		return []string{".env"}, nil
+	}
+
+	var r []string
+	for _, filename := range filenames {
+		dir, err := expandHome(filename)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			r = append(r, filename) // not found errors are left to reading
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, info := range infos {
+			name := info.Name()
+			if !info.IsDir() && (name == ".env" || strings.HasPrefix(name, ".env.")) {
+				r = append(r, filepath.Join(filename, name))
+				found = true
+			}
+		}
+		if !found && !SkipEmptyDirs {
+			return nil, fmt.Errorf("%s: no env files in directory", filename)
+		}
 	}
-	return filenames
+	return r, nil
 }
 
 func loadFile(filename string, overload bool, expand bool) error {
@@ -190,6 +1230,17 @@ func loadFile(filename string, overload bool, expand bool) error {
 		return err
 	}
 
+	applyEnv(envMap, overload)
+	return nil
+}
+
+// applyEnv sets the variables of envMap in ENV, returning how many it set.
+func applyEnv(envMap *EnvMap, overload bool) (set int) {
+	return applyEnvKeys(envMap, func(string) bool { return overload })
+}
+
+// applyEnvKeys is applyEnv, overriding the variables that overload says.
+func applyEnvKeys(envMap *EnvMap, overload func(key string) bool) (set int) {
 	currentEnv := map[string]bool{}
 	rawEnv := os.Environ()
 	for _, rawEnvLine := range rawEnv {
@@ -197,32 +1248,172 @@ func loadFile(filename string, overload bool, expand bool) error {
 		currentEnv[key] = true
 	}
 	envMap.Iter(func(k, v string) {
-		if !currentEnv[k] || overload {
+		if !currentEnv[k] || overload(k) {
 			os.Setenv(k, v)
+			set++
 		}
 	})
+	return
+}
 
-	return nil
+// readFiles merges the given files like Read does, but without touching the
+// environment of this process: where Read sets the keys of each file in ENV
+// for the references in later files to find, they are looked up in the keys
+// merged so far instead.
+func readFiles(filenames []string, expand bool) (*EnvMap, error) {
+	return readFilesWith(filenames, ParseOptions{Expand: expand})
+}
+
+// readFilesWith is readFiles with the given options.
+func readFilesWith(filenames []string, opts ParseOptions) (*EnvMap, error) {
+	envMap := NewEnvMap()
+	filenames, err := envFilenames(filenames)
+	if err != nil {
+		return envMap, err
+	}
+	for _, filename := range filenames {
+		individualEnvMap, err := readFileWith(filename, opts, envMap)
+		if err != nil {
+			return envMap, err
+		}
+		for _, pair := range individualEnvMap.entries {
+			envMap.setPair(pair)
+		}
+	}
+	return envMap, nil
+}
+
+// readContent returns the decoded content of an env file.
+func readContent(filename string) ([]byte, error) {
+	file, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, err := fileReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
 }
 
 func readFile(filename string, expand bool) (envMap *EnvMap, err error) {
-	file, err := os.Open(filename)
+	return readFileWith(filename, ParseOptions{Expand: expand}, nil)
+}
+
+// readFileWith is readFile with the given options, resolving references
+// against fallback, if not nil, before the environment.
+func readFileWith(filename string, opts ParseOptions, fallback *EnvMap) (envMap *EnvMap, err error) {
+	file, err := openFile(filename)
 	if err != nil {
 		return
 	}
 	defer file.Close()
 
-	return Parse(file, expand)
+	r, err := fileReader(file)
+	if err != nil {
+		return
+	}
+	p := newParser(opts)
+	p.fallback = fallback
+	if path, err := filepath.Abs(file.Name()); err == nil {
+		p.dir, p.including = filepath.Dir(path), []string{path}
+	}
+	err = p.parse(r)
+	return p.envMap, err
+}
+
+func openFile(filename string) (*os.File, error) {
+	filename, err := expandHome(filename)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filename)
+}
+
+// fileReader returns the decoded content of an opened env file, checking it
+// against MaxFileSize.
+func fileReader(file *os.File) (io.Reader, error) {
+	var r io.Reader = file
+	if MaxFileSize > 0 {
+		tooLarge := fmt.Errorf("%s: %w (limit is %d bytes, see MaxFileSize)", file.Name(), ErrFileTooLarge, MaxFileSize)
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > MaxFileSize {
+			return nil, tooLarge
+		}
+		// pipes and devices have no size to check up front
+		r = &sizeLimitReader{r: file, remaining: MaxFileSize, err: tooLarge}
+	}
+	return decodeReader(r)
+}
+
+// sizeLimitReader fails with err once more than remaining bytes are read.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	return n, err
+}
+
+// expandHome resolves a leading ~ or ~user in filename to the home directory,
+// the way a shell would.
+func expandHome(filename string) (string, error) {
+	if !strings.HasPrefix(filename, "~") {
+		return filename, nil
+	}
+	name, rest := filename[1:], ""
+	if i := strings.IndexAny(name, `/\`); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	var home string
+	if name == "" {
+		dir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		home = dir
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		home = u.HomeDir
+	}
+	return home + rest, nil
 }
 
 func parseLine(line string, envMap *EnvMap, expand bool) (key string, value string, err error) {
+	p := &parser{opts: ParseOptions{Expand: expand}, envMap: envMap}
+	return p.parseLine(line)
+}
+
+func (p *parser) parseLine(line string) (key string, value string, err error) {
+	p.raw = ""
+	p.quote = QuoteUnknown
+	p.valueErr = nil
 	if len(line) == 0 {
-		err = errors.New("zero length string")
+		err = p.fail(KindMalformedLine, "zero length string")
 		return
 	}
 
-	// ditch the comments (but keep quoted hashes)
-	if strings.Contains(line, "#") {
+	// ditch the comments (but keep quoted hashes, and those within JSON)
+	if end := p.jsonEnd(line); end >= 0 {
+		if i := strings.Index(line[end:], "#"); i >= 0 {
+			line = line[:end+i]
+		}
+	} else if strings.Contains(line, "#") {
 		segmentsBetweenHashes := strings.Split(line, "#")
 		quotesAreOpen := false
 		var segmentsToKeep []string
@@ -244,16 +1435,9 @@ func parseLine(line string, envMap *EnvMap, expand bool) (key string, value stri
 		line = strings.Join(segmentsToKeep, "#")
 	}
 
-	firstEquals := strings.Index(line, "=")
-	firstColon := strings.Index(line, ":")
-	splitString := strings.SplitN(line, "=", 2)
-	if firstColon != -1 && (firstColon < firstEquals || firstEquals == -1) {
-		//this is a yaml-style line
-		splitString = strings.SplitN(line, ":", 2)
-	}
-
+	splitString := p.split(line)
 	if len(splitString) != 2 {
-		err = errors.New("Can't separate key from value")
+		err = p.fail(KindMalformedLine, "Can't separate key from value")
 		return
 	}
 
@@ -264,80 +1448,391 @@ func parseLine(line string, envMap *EnvMap, expand bool) (key string, value stri
 	}
 	key = strings.TrimSpace(key)
 
-	re := regexp.MustCompile(`^\s*(?:export\s+)?(.*?)\s*$`)
-	key = re.ReplaceAllString(splitString[0], "$1")
+	key = keyRegex.ReplaceAllString(splitString[0], "$1")
+	if key != "" && strings.TrimSpace(splitString[0]) != splitString[0] {
+		p.report(KindKeyWhitespace, "whitespace around key %s", key)
+	}
+	appending := p.opts.Append && strings.HasSuffix(key, "+")
+	if appending {
+		key = strings.TrimSpace(strings.TrimSuffix(key, "+"))
+	}
+	if p.opts.ExpandKeys && strings.ContainsAny(key, "$%") {
+		expanded := p.expandKey(key)
+		if p.valueErr == nil && !validKey(expanded) {
+			p.failValue(KindInvalidKey, "key %s expands to invalid key %q", key, expanded)
+		}
+		key = expanded
+	}
 
 	// Parse the value
-	value = parseValue(splitString[1], envMap, expand)
+	value = p.parseValue(splitString[1])
+	resolved := false
+	if p.opts.ResolveSchemes && p.valueErr == nil && p.quote != QuoteSingle {
+		if v := p.resolveScheme(value); v != value {
+			if p.raw == "" {
+				p.raw = value // the reference as written, never the secret
+			}
+			value, resolved = v, true
+		}
+	}
+	err = p.valueErr
+	if appending {
+		if base, ok := p.lookup(key); ok {
+			value = base + value
+			if !resolved {
+				p.raw = "" // the base isn't written on the line
+			}
+		}
+	}
 	return
 }
 
-func parseValue(value string, envMap *EnvMap, expand bool) string {
+func (p *parser) parseValue(value string) string {
 
 	// trim
 	value = strings.Trim(value, " ")
 
+	if value != "" && (value[0] == '{' || value[0] == '[') {
+		p.report(KindUnquotedJSON, "unquoted JSON value; quote it, as in '%s'", value)
+	}
+
+	if p.opts.ConcatQuotes {
+		p.quote = quoteOf(value)
+		return p.concatValue(value)
+	}
+
+	// only a comment may follow a quoted value, and that is gone by now
+	if len(value) > 1 && (value[0] == '"' || value[0] == '\'') {
+		if i := closingQuote(value[1:], value[0]) + 2; i > 1 && i < len(value) {
+			if rest := strings.TrimSpace(value[i:]); rest != "" {
+				p.report(KindTrailingContent, "unexpected %q after quoted value", rest)
+			}
+			value = value[:i]
+		}
+	}
+	p.quote = quoteOf(value)
+	return p.unquote(value)
+}
+
+// quoteOf tells how value is quoted.
+func quoteOf(value string) Quote {
+	switch {
+	case singleQuotesRegex.MatchString(value):
+		return QuoteSingle
+	case doubleQuotesRegex.MatchString(value):
+		return QuoteDouble
+	}
+	return QuoteNone
+}
+
+// concatValue joins the quoted and unquoted parts of value, each unquoted in
+// its own way, the way the shell reads "a"'b'c as abc.
+func (p *parser) concatValue(value string) string {
+	var b strings.Builder
+	for value != "" {
+		i := strings.IndexAny(value, `"'`)
+		if i != 0 {
+			if i < 0 {
+				i = len(value)
+			}
+		} else if end := closingQuote(value[1:], value[0]); end >= 0 {
+			i = end + 2
+		} else {
+			// an unclosed quote is kept, as it is on its own
+			i = len(value)
+		}
+		b.WriteString(p.unquote(value[:i]))
+		value = value[i:]
+	}
+	p.raw = "" // the parts can't be quoted together as written
+	return b.String()
+}
+
+// unquote takes the quotes off value, if it is quoted, and resolves its
+// escapes and references as the quotes call for.
+func (p *parser) unquote(value string) string {
 	// check if we've got quoted values or possible escapes
 	if len(value) > 1 {
-		rs := regexp.MustCompile(`\A'(.*)'\z`)
-		singleQuotes := rs.FindStringSubmatch(value)
-
-		rd := regexp.MustCompile(`\A"(.*)"\z`)
-		doubleQuotes := rd.FindStringSubmatch(value)
+		singleQuotes := singleQuotesRegex.FindStringSubmatch(value)
+		doubleQuotes := doubleQuotesRegex.FindStringSubmatch(value)
 
 		if singleQuotes != nil || doubleQuotes != nil {
 			// pull the quotes off the edges
 			value = value[1 : len(value)-1]
 		}
 
-		if doubleQuotes != nil {
-			// expand newlines
-			escapeRegex := regexp.MustCompile(`\\.`)
-			value = escapeRegex.ReplaceAllStringFunc(value, func(match string) string {
-				c := strings.TrimPrefix(match, `\`)
-				switch c {
-				case "n":
-					return "\n"
-				case "r":
-					return "\r"
-				default:
-					return match
-				}
-			})
-			// unescape characters
-			e := regexp.MustCompile(`\\([^$])`)
-			value = e.ReplaceAllString(value, "$1")
+		if doubleQuotes != nil || (singleQuotes == nil && p.opts.EscapesInUnquoted) {
+			value = p.unescape(value)
 		}
 
-		if singleQuotes == nil && expand {
-			value = expandVariables(value, envMap)
+		if singleQuotes == nil && (p.opts.Expand || p.opts.ExpandPercent) {
+			if expanded := p.expandVariables(value); expanded != value {
+				p.raw = value
+				value = expanded
+			}
 		}
 	}
 
 	return value
 }
 
-func expandVariables(v string, m *EnvMap) string {
-	r := regexp.MustCompile(`(\\)?(\$)(\()?\{?([A-Z0-9_]+)?\}?`)
+// expandKey expands the references in key, failing on those to undefined
+// variables rather than leave part of the key out.
+func (p *parser) expandKey(key string) string {
+	onUndefined := p.opts.OnUndefined
+	p.opts.OnUndefined = UndefinedError
+	defer func() { p.opts.OnUndefined = onUndefined }()
+	return p.expandVariables(key)
+}
 
-	return r.ReplaceAllStringFunc(v, func(s string) string {
-		submatch := r.FindStringSubmatch(s)
+// unescape resolves the escape sequences in value, as double quotes call for.
+func (p *parser) unescape(value string) string {
+	// expand newlines
+	value = escapeRegex.ReplaceAllStringFunc(value, func(match string) string {
+		c := strings.TrimPrefix(match, `\`)
+		switch c {
+		case "n":
+			return "\n"
+		case "r":
+			return "\r"
+		default:
+			if p.opts.StrictEscapes && !strings.Contains(knownEscapes, c) {
+				p.failValue(KindUnknownEscape, "unknown escape sequence %s", match)
+			}
+			return match
+		}
+	})
+	// unescape characters
+	return unescapeRegex.ReplaceAllString(value, "$1")
+}
 
-		if submatch == nil {
+// expandVariables replaces $NAME and ${NAME} references in v, or %NAME% ones
+// if so configured, with the values read so far, falling back on the process
+// environment.
+//
+// Names are made of upper case letters, digits and underscores, unless
+// LowercaseNames is set, so that a $ before anything else, as in pa$word, is
+// kept as written. An unbraced reference takes the longest run of name
+// characters, as in the shell: with FOO=x, $FOO_BAR refers to FOO_BAR, not to
+// FOO. To glue a reference onto following name characters use braces:
+// ${FOO}_BAR.
+// A brace that is never closed is not a reference, and a preceding backslash,
+// or whatever ExpandEscape is set to, keeps the reference literal.
+//
+// A braced reference may name where to look the variable up: ${env:NAME} only
+// in the environment, say for the value a key had before the file redefined
+// it, and ${file:NAME} only in what was read.
+func (p *parser) expandVariables(v string) string {
+	return p.referenceRegex().ReplaceAllStringFunc(v, func(s string) string {
+		name, source, escape := p.reference(s)
+		if escape != "" {
+			return s[len(escape):]
+		}
+		if name == "" {
 			return s
 		}
-		if submatch[1] == "\\" || submatch[2] == "(" {
-			return submatch[0][1:]
-		} else if submatch[4] != "" {
-			if val, ok := m.Get(submatch[4]); ok >= 0 {
-				return val
+		var val string
+		var ok bool
+		switch source {
+		case "env":
+			val, ok = p.lookupEnv(name)
+		case "file":
+			val, ok = p.lookupFile(name)
+		default:
+			val, ok = p.lookup(name)
+		}
+		if !ok {
+			if p.opts.OnUndefined == UndefinedError {
+				p.failValue(KindUndefinedVariable, "undefined variable %s", name)
+			} else {
+				p.report(KindUndefinedVariable, "undefined variable %s", name)
+			}
+			if p.opts.OnUndefined == UndefinedKeep {
+				val = s
 			}
-			return os.Getenv(submatch[4])
 		}
-		return s
+		return val
 	})
 }
 
+// references returns the names of the variables v refers to, leaving out the
+// references kept literal and those to the environment only.
+func (p *parser) references(v string) []string {
+	var names []string
+	for _, s := range p.referenceRegex().FindAllString(v, -1) {
+		if name, source, escape := p.reference(s); name != "" && source != "env" && escape == "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reference picks apart a match of referenceRegex into the name referred to,
+// if any, where to look it up, if the reference says, and the escape that
+// keeps it literal, if any.
+func (p *parser) reference(s string) (name, source, escape string) {
+	r := p.referenceRegex()
+	submatch := r.FindStringSubmatch(s)
+	if submatch == nil {
+		return "", "", ""
+	}
+	group := func(name string) string {
+		if i := r.SubexpIndex(name); i >= 0 {
+			return submatch[i]
+		}
+		return ""
+	}
+	return group("braced") + group("bare") + group("percent"), group("source"), group("escape")
+}
+
+// referenceRegex returns the expression matching references, built on first
+// use to suit the options.
+func (p *parser) referenceRegex() *regexp.Regexp {
+	if p.expandRegex == nil {
+		var patterns []string
+		if p.opts.Expand || p.opts.ExpandKeys {
+			escape := p.opts.ExpandEscape
+			if escape == 0 {
+				escape = '\\'
+			}
+			name := `[A-Z0-9_]+`
+			if p.opts.LowercaseNames {
+				name = `[A-Za-z0-9_]+`
+			}
+			patterns = append(patterns, `(?P<escape>`+regexp.QuoteMeta(string(escape))+`)?\$(?:\{(?:(?P<source>env|file):)?(?P<braced>`+name+`)\}|(?P<bare>`+name+`))?`)
+		}
+		if p.opts.ExpandPercent {
+			patterns = append(patterns, `%(?P<percent>[A-Za-z0-9_]+)%`)
+		}
+		p.expandRegex = regexp.MustCompile(strings.Join(patterns, "|"))
+	}
+	return p.expandRegex
+}
+
+// sectionName recognises [section] header lines.
+func sectionName(line string) (string, bool) {
+	submatch := sectionRegex.FindStringSubmatch(line)
+	if submatch == nil {
+		return "", false
+	}
+	return submatch[1], true
+}
+
+// separator returns the separator pinned by the options, or empty to pick
+// one per line.
+func (p *parser) separator() string {
+	if p.opts.Separator == "" && DisableColonSeparator {
+		return "="
+	}
+	return p.opts.Separator
+}
+
+// split splits line into its key and value parts, at the separator the
+// options call for, or returns it whole if there is none.
+func (p *parser) split(line string) []string {
+	if re := p.opts.SeparatorRegex; re != nil {
+		if loc := re.FindStringIndex(line); loc != nil {
+			return []string{line[:loc[0]], line[loc[1]:]}
+		}
+		return []string{line}
+	}
+	if sep := p.separator(); sep != "" {
+		return strings.SplitN(line, sep, 2)
+	}
+
+	firstEquals := strings.Index(line, "=")
+	firstColon := strings.Index(line, ":")
+	splitString := strings.SplitN(line, "=", 2)
+	if firstColon != -1 && (firstColon < firstEquals || firstEquals == -1) {
+		//this is a yaml-style line
+		splitString = strings.SplitN(line, ":", 2)
+	}
+	return splitString
+}
+
+// jsonEnd returns where the JSON object or array that starts the value on
+// line ends, or -1 if the value doesn't start with a balanced one, so that
+// the hashes and quotes within it can be told from a comment.
+func (p *parser) jsonEnd(line string) int {
+	parts := p.split(line)
+	if len(parts) != 2 {
+		return -1
+	}
+	value := strings.TrimLeft(parts[1], " \t")
+	if value == "" || (value[0] != '{' && value[0] != '[') {
+		return -1
+	}
+
+	depth, inString := 0, false
+	for i := len(line) - len(value); i < len(line); i++ {
+		switch c := line[i]; {
+		case inString && c == '\\':
+			i++ // skip the escaped character
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// unclosedQuote is the function of the same name, splitting line as the
+// options call for.
+func (p *parser) unclosedQuote(line string) byte {
+	if parts := p.split(line); len(parts) == 2 {
+		return openQuote(parts[1])
+	}
+	return 0
+}
+
+// unclosedQuote returns the quote that opens the value on line, after the
+// first of seps, without closing it, or 0 if there is none.
+func unclosedQuote(line string, seps string) byte {
+	i := strings.IndexAny(line, seps)
+	if i < 0 {
+		return 0
+	}
+	return openQuote(line[i+1:])
+}
+
+// openQuote returns the quote that opens value without closing it, or 0 if
+// there is none.
+func openQuote(value string) byte {
+	value = strings.TrimLeft(value, " \t")
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return 0
+	}
+	if closingQuote(value[1:], value[0]) >= 0 {
+		return 0
+	}
+	return value[0]
+}
+
+// closingQuote returns the index of the first quote q in s, skipping those
+// escaped by a backslash within double quotes, or -1 if there is none.
+func closingQuote(s string, q byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// validKey tells whether key makes a sensible variable name.
+func validKey(key string) bool {
+	return validKeyRegex.MatchString(key)
+}
+
 func isIgnoredLine(line string) bool {
 	trimmedLine := strings.TrimSpace(line)
 	return len(trimmedLine) == 0 || strings.HasPrefix(trimmedLine, "#")
@@ -0,0 +1,94 @@
+package godotenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// MarshalJSON encodes the map as a JSON object of strings, with the keys in
+// the order of the map.
+func (m *EnvMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range m.entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(p.Val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON sets the entries of a JSON object of strings in the map, in
+// the order of the object. Values that aren't strings are an error.
+func (m *EnvMap) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object, got %v", tok)
+	}
+	if m.keys == nil {
+		m.keys = make(map[string]int)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string) // object keys are always strings
+		var val string
+		if err := dec.Decode(&val); err != nil {
+			return fmt.Errorf("value of %s: %w", key, err)
+		}
+		m.Set(key, val)
+	}
+	_, err := dec.Token() // the closing brace
+	return err
+}
+
+// WriteJSON writes the map to a file as a JSON object, as by MarshalJSON,
+// readable only by its owner. The file is replaced atomically, as by Write.
+func WriteJSON(envMap *EnvMap, filename string) error {
+	filename, err := expandHome(filename)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(envMap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, append(data, '\n'), 0600)
+}
+
+// ReadJSON reads a map from a file written by WriteJSON, or any JSON object of
+// strings, keeping the order of its keys. References in values are kept as
+// they are; Resolve expands them as parsing would.
+func ReadJSON(filename string) (*EnvMap, error) {
+	name, err := expandHome(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	envMap := NewEnvMap()
+	if err := json.Unmarshal(data, envMap); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return envMap, nil
+}
@@ -0,0 +1,70 @@
+package godotenv
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteReadJSON(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("Z", "last \"quoted\"")
+	m.Set("A", "line\nbreak")
+	m.Set("URL", "http://${HOST}/")
+
+	filename := filepath.Join(t.TempDir(), "env.json")
+	if err := WriteJSON(m, filename); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected WriteJSON to write mode 0600, got %v %v", info.Mode().Perm(), err)
+	}
+
+	read, err := ReadJSON(filename)
+	if err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(read.Pairs(), m.Pairs()) {
+		t.Errorf("Expected %v to round-trip, got %v", m.Pairs(), read.Pairs())
+	}
+
+	ioutil.WriteFile(filename, []byte(`{"A": 1}`), 0600)
+	if _, err := ReadJSON(filename); err == nil {
+		t.Errorf("Expected ReadJSON to fail on a value that isn't a string")
+	}
+}
+
+func TestWriteJSONExpandsHome(t *testing.T) {
+	home := t.TempDir()
+	os.Clearenv()
+	os.Setenv("HOME", home)
+	os.Setenv("USERPROFILE", home)
+
+	if err := WriteJSON(NewEnvMap().With("A", "1"), "~/env.json"); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "env.json")); err != nil {
+		t.Errorf("Expected WriteJSON to expand ~ to %s: %v", home, err)
+	}
+}
+
+func TestEnvMapMarshalJSON(t *testing.T) {
+	m := NewEnvMap()
+	m.Set("B", "2")
+	m.Set("A", "1")
+	data, err := json.Marshal(m)
+	if err != nil || string(data) != `{"B":"2","A":"1"}` {
+		t.Errorf("Failed marshal JSON: %s %v", data, err)
+	}
+
+	var empty EnvMap
+	if err := json.Unmarshal([]byte(`{"B":"2","A":"1"}`), &empty); err != nil || empty.Keys()[0] != "B" {
+		t.Errorf("Failed unmarshal JSON into a zero map: %v %v", empty.Keys(), err)
+	}
+	if err := json.Unmarshal([]byte(`["A"]`), &empty); err == nil {
+		t.Errorf("Failed unmarshal JSON of an array: expected an error")
+	}
+}
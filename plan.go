@@ -0,0 +1,54 @@
+package godotenv
+
+import "os"
+
+// PlanAction says what loading files would do to a variable.
+type PlanAction int
+
+const (
+	// PlanSet sets a variable that is not in ENV yet.
+	PlanSet PlanAction = iota
+	// PlanOverride replaces the value of a variable in ENV.
+	PlanOverride
+	// PlanUnchanged sets a variable in ENV to the value it already has.
+	PlanUnchanged
+)
+
+// PlanEntry is what loading files would do to one variable.
+type PlanEntry struct {
+	Key    string
+	Action PlanAction
+
+	// Value is the value loading would set.
+	Value string
+
+	// OldValue is the value in ENV now, for PlanOverride and PlanUnchanged.
+	OldValue string
+}
+
+// OverloadPlan reports what Overload would do with the same files, without
+// changing ENV, so that the values about to be clobbered can be reviewed
+// first. There is an entry for each key, in the order of the files.
+//
+// As with Overload, references in each file resolve against the keys of the
+// files before it, ahead of ENV.
+func OverloadPlan(filenames ...string) ([]PlanEntry, error) {
+	envMap, err := readFiles(filenames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]PlanEntry, 0, envMap.Len())
+	envMap.Iter(func(k, v string) {
+		entry := PlanEntry{Key: k, Value: v}
+		if old, ok := os.LookupEnv(k); ok {
+			entry.OldValue = old
+			entry.Action = PlanOverride
+			if old == v {
+				entry.Action = PlanUnchanged
+			}
+		}
+		plan = append(plan, entry)
+	})
+	return plan, nil
+}
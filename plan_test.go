@@ -0,0 +1,67 @@
+package godotenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverloadPlan(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("OPTION_A", "current")
+	os.Setenv("OPTION_B", "2")
+
+	plan, err := OverloadPlan("fixtures/plain.env")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(plan) != 7 {
+		t.Fatalf("Failed overload plan: %+v", plan)
+	}
+	expected := []PlanEntry{
+		{Key: "OPTION_A", Action: PlanOverride, Value: "1", OldValue: "current"},
+		{Key: "OPTION_B", Action: PlanUnchanged, Value: "2", OldValue: "2"},
+		{Key: "OPTION_C", Action: PlanSet, Value: "3"},
+	}
+	if !reflect.DeepEqual(plan[:3], expected) {
+		t.Errorf("Expected plan to start with %+v, got %+v", expected, plan[:3])
+	}
+	if os.Getenv("OPTION_A") != "current" || os.Getenv("OPTION_C") != "" {
+		t.Errorf("Failed overload plan: it changed ENV")
+	}
+
+	if _, err := OverloadPlan("fixtures/missing.env"); err == nil {
+		t.Errorf("Failed overload plan: expected an error for a missing file")
+	}
+}
+
+func TestOverloadPlanReferencesAcrossFiles(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOST", "env")
+	dir := t.TempDir()
+	h := filepath.Join(dir, "h.env")
+	r := filepath.Join(dir, "r.env")
+	ioutil.WriteFile(h, []byte("HOST=h"), 0644)
+	ioutil.WriteFile(r, []byte("URL=$HOST/x"), 0644)
+
+	plan, err := OverloadPlan(h, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []PlanEntry{
+		{Key: "HOST", Action: PlanOverride, Value: "h", OldValue: "env"},
+		{Key: "URL", Action: PlanSet, Value: "h/x"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Expected plan %+v, got %+v", expected, plan)
+	}
+
+	if err := Overload(h, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v := os.Getenv("URL"); v != plan[1].Value {
+		t.Errorf("Expected Overload to set URL as planned, got %q", v)
+	}
+}
@@ -0,0 +1,62 @@
+package godotenv
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// resolvers holds the functions registered with RegisterResolver, by scheme.
+var resolvers = struct {
+	sync.RWMutex
+	schemes map[string]func(ref string) (string, error)
+}{schemes: map[string]func(string) (string, error){}}
+
+var schemeRegex = regexp.MustCompile(`(?s)^([A-Za-z][A-Za-z0-9+.-]*)://(.*)$`)
+
+// RegisterResolver has values of the form scheme://ref resolved by fn, when
+// parsed with ResolveSchemes set, so that secrets can be kept in a secret
+// manager and fetched as env files are read:
+//
+//	godotenv.RegisterResolver("secret", func(ref string) (string, error) {
+//		return vault.Read(ref) // ref is vault/db/password for secret://vault/db/password
+//	})
+//
+// Schemes are not case sensitive. A nil fn removes the resolver for scheme.
+// Resolvers may be registered from any goroutine.
+func RegisterResolver(scheme string, fn func(ref string) (string, error)) {
+	resolvers.Lock()
+	defer resolvers.Unlock()
+	scheme = strings.ToLower(scheme)
+	if fn == nil {
+		delete(resolvers.schemes, scheme)
+	} else {
+		resolvers.schemes[scheme] = fn
+	}
+}
+
+// resolveScheme returns what the resolver for the scheme of value resolves
+// it to, or value itself if it has no scheme.
+func (p *parser) resolveScheme(value string) string {
+	match := schemeRegex.FindStringSubmatch(value)
+	if match == nil {
+		return value
+	}
+	scheme := strings.ToLower(match[1])
+	resolvers.RLock()
+	fn := resolvers.schemes[scheme]
+	resolvers.RUnlock()
+
+	if fn == nil {
+		if p.opts.StrictSchemes {
+			p.failValue(KindUnresolved, "no resolver for scheme %s", scheme)
+		}
+		return value
+	}
+	resolved, err := fn(match[2])
+	if err != nil {
+		p.failValue(KindUnresolved, "resolving %s: %v", value, err)
+		return value
+	}
+	return resolved
+}
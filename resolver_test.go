@@ -0,0 +1,57 @@
+package godotenv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveSchemes(t *testing.T) {
+	secrets := map[string]string{"vault/db/password": "s3cret"}
+	RegisterResolver("secret", func(ref string) (string, error) {
+		if v, ok := secrets[ref]; ok {
+			return v, nil
+		}
+		return "", errors.New("no such secret")
+	})
+	defer RegisterResolver("secret", nil)
+
+	input := "DB_PASS=secret://vault/db/password\nQUOTED=\"SECRET://vault/db/password\"\nLITERAL='secret://vault/db/password'\nURL=https://example.com"
+	envMap, err := ParseWithOptions(strings.NewReader(input), ParseOptions{ResolveSchemes: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"DB_PASS": "s3cret",
+		"QUOTED":  "s3cret",
+		"LITERAL": "secret://vault/db/password",
+		"URL":     "https://example.com",
+	}
+	for k, v := range expected {
+		if got, _ := envMap.Get(k); got != v {
+			t.Errorf("Failed resolve %s: expected %q, got %q", k, v, got)
+		}
+	}
+
+	if raw := MarshalRaw(envMap); strings.Contains(raw, "s3cret") || !strings.Contains(raw, `DB_PASS="secret://vault/db/password"`) {
+		t.Errorf("Expected MarshalRaw to keep the references, not the secrets, got %q", raw)
+	}
+	if p := envMap.Pairs()[0]; p.RawVal() != "secret://vault/db/password" {
+		t.Errorf("Expected the raw value to be the reference, got %q", p.RawVal())
+	}
+
+	envMap, _ = ParseWithOptions(strings.NewReader(input), ParseOptions{})
+	if got, _ := envMap.Get("DB_PASS"); got != "secret://vault/db/password" {
+		t.Errorf("Expected no resolving without ResolveSchemes, got %q", got)
+	}
+
+	_, err = ParseWithOptions(strings.NewReader("A=1\nB=secret://missing"), ParseOptions{ResolveSchemes: true})
+	if d, ok := err.(Diagnostic); !ok || d.Line != 2 || d.Kind != KindUnresolved {
+		t.Errorf("Expected the resolver error on line 2, got %v", err)
+	}
+
+	opts := ParseOptions{ResolveSchemes: true, StrictSchemes: true}
+	if _, err = ParseWithOptions(strings.NewReader(input), opts); err == nil {
+		t.Errorf("Expected an error for a scheme without a resolver")
+	}
+}
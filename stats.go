@@ -0,0 +1,57 @@
+package godotenv
+
+import (
+	"bytes"
+	"time"
+)
+
+// Stats describes the work done by LoadStats.
+type Stats struct {
+	Files    int           // files read
+	Lines    int           // lines read, blank lines and comments included
+	Set      int           // keys set in ENV
+	Skipped  int           // keys left alone because ENV already had them
+	Duration time.Duration // time taken to read, parse and load it all
+}
+
+// LoadStats loads the files just like Load, and reports what it took. This
+// helps tell why loading big files slows a service down as it starts.
+//
+// If an error occurs, the Stats cover what was done up to that point.
+func LoadStats(filenames ...string) (stats Stats, err error) {
+	start := time.Now()
+	defer func() { stats.Duration = time.Since(start) }()
+
+	filenames, err = envFilenames(filenames)
+	if err != nil {
+		return
+	}
+	for _, filename := range filenames {
+		var content []byte
+		content, err = readContent(filename)
+		if err != nil {
+			return
+		}
+		stats.Files++
+		stats.Lines += countLines(content)
+
+		var envMap *EnvMap
+		envMap, err = Parse(bytes.NewReader(content), true)
+		if err != nil {
+			return
+		}
+		set := applyEnv(envMap, false)
+		stats.Set += set
+		stats.Skipped += envMap.Len() - set
+	}
+	return
+}
+
+// countLines counts the lines of content as readLines splits them.
+func countLines(content []byte) int {
+	n := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++ // the last line has no line break
+	}
+	return n
+}
@@ -0,0 +1,30 @@
+package godotenv
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLoadStats(t *testing.T) {
+	os.Clearenv()
+	stats, err := LoadStats("fixtures/plain.env", "fixtures/override.env")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// override.env sets OPTION_A again, which plain.env already loaded
+	if stats.Files != 2 || stats.Lines != 9 || stats.Set != 8 || stats.Skipped != 1 {
+		t.Errorf("Failed load stats: %+v", stats)
+	}
+	if stats.Duration <= 0 {
+		t.Errorf("Failed load stats duration: %v", stats.Duration)
+	}
+	if os.Getenv("OPTION_A") != "1" || os.Getenv("OPTION_H") != "3-2" {
+		t.Errorf("Failed load stats loading: OPTION_A=%s OPTION_H=%s", os.Getenv("OPTION_A"), os.Getenv("OPTION_H"))
+	}
+
+	os.Clearenv()
+	stats, err = LoadStats("fixtures/plain.env", "fixtures/missing.env")
+	if err == nil || stats.Files != 1 || stats.Set != 7 {
+		t.Errorf("Failed load stats on error: %+v %v", stats, err)
+	}
+}
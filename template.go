@@ -0,0 +1,64 @@
+package godotenv
+
+import (
+	"io"
+	"strings"
+	"text/template"
+)
+
+// ParseTemplate reads env content like Parse, without expanding references,
+// and then takes each value for a text/template, run against the values of
+// the keys before it, so that values can be derived in ways references can't:
+//
+//	HOST=db.internal
+//	DSN={{ .USER | default "app" }}@{{ .HOST | upper }}
+//
+// Keys not read are empty. Besides those in funcs, which take precedence,
+// templates can call
+//
+//	default D V  V, or D if V is empty
+//	upper S      S in upper case
+//	lower S      S in lower case
+//	env NAME     the value of key NAME, or empty
+//
+// Templates have no access to the environment of the process, or anything
+// else outside the content, unless funcs gives it, as with
+// template.FuncMap{"env": os.Getenv}.
+func ParseTemplate(r io.Reader, funcs template.FuncMap) (*EnvMap, error) {
+	raw, err := Parse(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	envMap := NewEnvMapCap(raw.Len())
+	data := make(map[string]string, raw.Len())
+	builtins := template.FuncMap{
+		"default": func(def, val string) string {
+			if val == "" {
+				return def
+			}
+			return val
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"env":   func(name string) string { return data[name] },
+	}
+
+	for _, p := range raw.entries {
+		tmpl, err := template.New(p.Key).Funcs(builtins).Funcs(funcs).Option("missingkey=zero").Parse(p.Val)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		pair := Pair{Key: p.Key, Val: b.String()}
+		if pair.Val != p.Val {
+			pair.Raw = p.Val
+		}
+		envMap.setPair(pair)
+		data[p.Key] = pair.Val
+	}
+	return envMap, nil
+}
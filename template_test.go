@@ -0,0 +1,48 @@
+package godotenv
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestParseTemplate(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("SECRET", "from env")
+	input := strings.Join([]string{
+		"HOST=db.internal",
+		"UPPER={{ .HOST | upper }}",
+		`DSN={{ .USER | default "app" }}@{{ env "HOST" }}`,
+		`NOENV={{ env "SECRET" }}`,
+		"PLAIN=$HOST",
+	}, "\n")
+
+	envMap, err := ParseTemplate(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Pair{
+		{Key: "HOST", Val: "db.internal"},
+		{Key: "UPPER", Val: "DB.INTERNAL", Raw: "{{ .HOST | upper }}"},
+		{Key: "DSN", Val: "app@db.internal", Raw: `{{ .USER | default "app" }}@{{ env "HOST" }}`},
+		{Key: "NOENV", Val: "", Raw: `{{ env "SECRET" }}`},
+		{Key: "PLAIN", Val: "$HOST"},
+	}
+	if !reflect.DeepEqual(envMap.Pairs(), expected) {
+		t.Errorf("Expected %v, got %v", expected, envMap.Pairs())
+	}
+
+	envMap, err = ParseTemplate(strings.NewReader(`A={{ env "SECRET" | lower }}`), template.FuncMap{"env": os.Getenv})
+	if v, _ := envMap.Get("A"); err != nil || v != "from env" {
+		t.Errorf("Failed template with env from funcs: %q %v", v, err)
+	}
+
+	if _, err := ParseTemplate(strings.NewReader("A={{ .B"), nil); err == nil {
+		t.Errorf("Expected an error for a malformed template")
+	}
+	if _, err := ParseTemplate(strings.NewReader("A={{ nope }}"), nil); err == nil {
+		t.Errorf("Expected an error for an unknown function")
+	}
+}
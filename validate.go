@@ -0,0 +1,63 @@
+package godotenv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Required returns the keys marked as required, as by a # @required
+// directive when parsed with RequiredDirectives, in order.
+func (m *EnvMap) Required() []string {
+	var r []string
+	for _, p := range m.entries {
+		if p.Required {
+			r = append(r, p.Key)
+		}
+	}
+	return r
+}
+
+// Validate checks that each of the required variables is set in ENV, to a
+// value that isn't empty. The error lists those that aren't.
+func Validate(required ...string) error {
+	var missing []string
+	seen := make(map[string]bool, len(required))
+	for _, key := range required {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required keys: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// LoadAndValidate loads the files as Load does, then checks as by Validate
+// that the keys marked with a # @required directive in them are set, be it by
+// the files or by ENV already. This keeps which variables are required next
+// to them in the file:
+//
+//	# @required
+//	API_KEY=
+func LoadAndValidate(filenames ...string) error {
+	filenames, err := envFilenames(filenames)
+	if err != nil {
+		return err
+	}
+	var required []string
+	for _, filename := range filenames {
+		envMap, err := readFileWith(filename, ParseOptions{Expand: true, RequiredDirectives: true}, nil)
+		if err != nil {
+			return err
+		}
+		applyEnv(envMap, false)
+		required = append(required, envMap.Required()...)
+	}
+	return Validate(required...)
+}
@@ -0,0 +1,50 @@
+package godotenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequiredDirectives(t *testing.T) {
+	input := "# @required\nA=1\n\n# @required\n# the B key\nB=2\n  #  @required  \nC=\nD=4"
+	envMap, err := ParseWithOptions(strings.NewReader(input), ParseOptions{RequiredDirectives: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := envMap.Required(); !reflect.DeepEqual(r, []string{"A", "C"}) {
+		t.Errorf("Failed required directives: %v", r)
+	}
+
+	envMap, _ = ParseWithOptions(strings.NewReader(input), ParseOptions{})
+	if r := envMap.Required(); len(r) != 0 {
+		t.Errorf("Expected no required keys without RequiredDirectives, got %v", r)
+	}
+}
+
+func TestLoadAndValidate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ".env")
+	ioutil.WriteFile(filename, []byte("# @required\nAPI_KEY=\n# @required\nHOST=localhost\nOPTIONAL=\n"), 0644)
+
+	os.Clearenv()
+	err := LoadAndValidate(filename)
+	if err == nil || err.Error() != "missing required keys: API_KEY" {
+		t.Errorf("Expected API_KEY to be missing, got %v", err)
+	}
+	if os.Getenv("HOST") != "localhost" {
+		t.Errorf("Expected the file to be loaded even so")
+	}
+
+	os.Clearenv()
+	os.Setenv("API_KEY", "secret")
+	if err := LoadAndValidate(filename); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := Validate("API_KEY", "UNSET_A", "UNSET_B", "UNSET_A"); err == nil || err.Error() != "missing required keys: UNSET_A, UNSET_B" {
+		t.Errorf("Failed validate: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package godotenv
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchInterval is how often WatchFile checks its file for changes.
+var WatchInterval = time.Second
+
+// WatchFile calls onChange with the freshly parsed contents of filename,
+// expanded as by Read, whenever they change, until ctx is done. It returns the
+// error of ctx then, or one from reading filename to begin with. Nothing is
+// set in ENV; that is up to onChange.
+//
+// The file is polled every WatchInterval, and only read once it has stayed
+// the same for an interval, so that a burst of writes gives a single call.
+// Polling is all there is: file system notifications would take a dependency
+// this package does without, miss changes on some network file systems, and
+// lose track of files that editors replace by renaming others into place.
+// Writes that leave the keys and values as they were, as judged by HashSum,
+// give none. If the file can't be read, onChange gets the error instead.
+func WatchFile(ctx context.Context, filename string, onChange func(*EnvMap, error)) error {
+	envMap, err := readFile(filename, true)
+	if err != nil {
+		return err
+	}
+	sum := envMap.HashSum()
+	last := statFile(filename)
+	pending := false
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		stamp := statFile(filename)
+		if stamp != last {
+			// still being written, perhaps; wait for it to settle
+			last, pending = stamp, true
+			continue
+		}
+		if !pending {
+			continue
+		}
+		pending = false
+
+		envMap, err := readFile(filename, true)
+		if err != nil {
+			sum = "" // report the content again once it is back
+			onChange(nil, err)
+			continue
+		}
+		if s := envMap.HashSum(); s != sum {
+			sum = s
+			onChange(envMap, nil)
+		}
+	}
+}
+
+// fileStamp tells apart versions of a file, or its absence.
+type fileStamp struct {
+	modTime int64
+	size    int64
+	missing bool
+}
+
+func statFile(filename string) fileStamp {
+	if name, err := expandHome(filename); err == nil {
+		if info, err := os.Stat(name); err == nil {
+			return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+	return fileStamp{missing: true}
+}
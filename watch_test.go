@@ -0,0 +1,83 @@
+package godotenv
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	defer func(interval time.Duration) { WatchInterval = interval }(WatchInterval)
+	WatchInterval = 10 * time.Millisecond
+
+	filename := filepath.Join(t.TempDir(), ".env")
+	write := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// coarse file system clocks might not tell the writes apart otherwise
+		os.Chtimes(filename, mtime, mtime)
+	}
+	start := time.Now().Add(-time.Hour)
+	write("A=1", start)
+
+	type change struct {
+		envMap *EnvMap
+		err    error
+	}
+	changes := make(chan change, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- WatchFile(ctx, filename, func(envMap *EnvMap, err error) {
+			changes <- change{envMap, err}
+		})
+	}()
+	next := func() (change, bool) {
+		select {
+		case c := <-changes:
+			return c, true
+		case <-time.After(100 * WatchInterval):
+			return change{}, false
+		}
+	}
+
+	time.Sleep(5 * WatchInterval)
+	write("A=2", start.Add(time.Second))
+	if c, ok := next(); !ok || c.err != nil || c.envMap == nil {
+		t.Fatalf("Failed watch change: %v %v", ok, c.err)
+	} else if v, _ := c.envMap.Get("A"); v != "2" {
+		t.Errorf("Failed watch change value: %s", v)
+	}
+
+	// rewriting the same content is not a change
+	write("A=2", start.Add(2*time.Second))
+	time.Sleep(10 * WatchInterval)
+	select {
+	case c := <-changes:
+		t.Errorf("Failed watch ignoring unchanged content: %v", c)
+	default:
+	}
+
+	os.Remove(filename)
+	if c, ok := next(); !ok || c.err == nil {
+		t.Errorf("Failed watch removed file: %v %v", ok, c.err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Failed watch cancel: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Failed watch cancel: still running")
+	}
+
+	if err := WatchFile(context.Background(), filename, nil); err == nil {
+		t.Errorf("Failed watch missing file")
+	}
+}